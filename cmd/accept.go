@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dimitri/regresql/regresql"
+	"github.com/spf13/cobra"
+)
+
+// Command Flags
+var (
+	acceptOpts  regresql.AcceptOptions
+	acceptColor string
+)
+
+// acceptCmd represents the accept command
+var acceptCmd = &cobra.Command{
+	Use:   "accept [flags] [query-file ...]",
+	Short: "Accept changed results as the new expected ones",
+	Long: `Walk the results of the last 'regresql test' run that differ from the
+expected ones, show each diff and ask what to do with it:
+
+  a  accept: copy the actual result to the expected file
+  s  skip: keep the expected file as it is
+  e  edit: open the expected file in $EDITOR, then ask again
+  A  accept this result and all the remaining ones
+  q  quit
+
+Use --all to accept every changed result without asking, and --match to
+only consider the results whose name matches a glob pattern, such as
+'src/sql/artist.*.out'. When query file paths are given as arguments, only
+the results of those files are considered.
+
+Use --target to consider the results of a 'regresql test --target' run, found
+in regresql/out/<target>, rather than the ones of the default target. With
+several targets, such as --target all, the results of each target are
+accepted into the expected files of the PostgreSQL major version it runs,
+such as artist.1.pg16.out, and a result is skipped when another target
+already accepted a different one into the same file.
+
+Results are compared as 'regresql test' does, to the expected files of the
+PostgreSQL major version the tests ran against when they exist, and results
+within the tolerance rules are not considered changed. Use --pg to accept into the
+expected files of a PostgreSQL major version, such as artist.1.pg16.out,
+which are created when needed: the generic expected files are left alone.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkDirectory(cwd); err != nil {
+			fmt.Printf(err.Error())
+			os.Exit(1)
+		}
+		color, err := useColor(acceptColor)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		acceptOpts.Color = color
+		regresql.Accept(cwd, args, acceptOpts)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(acceptCmd)
+
+	acceptCmd.Flags().StringVarP(&cwd, "cwd", "C", ".", "Change to Directory")
+	acceptCmd.Flags().BoolVar(&acceptOpts.All, "all", false, "Accept every changed result without asking")
+	acceptCmd.Flags().StringVar(&acceptOpts.Match, "match", "", "Only consider results whose name matches this glob pattern")
+	acceptCmd.Flags().StringVar(&acceptColor, "color", "auto", "Colorize the output: auto, always or never")
+	acceptCmd.Flags().StringVar(&acceptOpts.Target, "target", "", "Consider the results of these targets of regress.yaml, or \"all\"")
+	acceptCmd.Flags().IntVar(&acceptOpts.PgMajor, "pg", 0, "Accept into the expected files of this PostgreSQL major version (e.g. 16), creating them when needed")
+}
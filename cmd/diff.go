@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dimitri/regresql/regresql"
+	"github.com/spf13/cobra"
+)
+
+// Command Flags
+var (
+	diffOpts  regresql.DiffOptions
+	diffColor string
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [flags] [query-file ...]",
+	Short: "Show differences between actual and expected results",
+	Long: `Show differences between the actual results of the last 'regresql test'
+run, found in regresql/out, and the expected results in regresql/expected.
+
+The queries are not run again, and no database connection is needed. When
+query file paths are given as arguments, only the results of those files
+are compared.
+
+Use --target to compare the results of a 'regresql test --target' run, found
+in regresql/out/<target>, with the expected results.
+
+The results of each target are compared to the version-specific expected
+files (e.g. query.pg16.out) of the PostgreSQL major version the tests ran
+against, when they exist, as 'regresql test' does. Use --pg to compare with
+the expected files of another version.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := checkDirectory(cwd); err != nil {
+			fmt.Printf(err.Error())
+			os.Exit(1)
+		}
+		color, err := useColor(diffColor)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		diffOpts.Color = color
+		regresql.Diff(cwd, args, diffOpts)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVarP(&cwd, "cwd", "C", ".", "Change to Directory")
+	diffCmd.Flags().IntVarP(&diffOpts.Context, "unified", "U", 3, "Number of context lines in unified diffs")
+	diffCmd.Flags().BoolVarP(&diffOpts.SideBySide, "side-by-side", "y", false, "Output in two columns")
+	diffCmd.Flags().IntVarP(&diffOpts.Width, "width", "W", 130, "Output width of side-by-side diffs")
+	diffCmd.Flags().BoolVar(&diffOpts.Stat, "stat", false, "Only output a summary of the changes")
+	diffCmd.Flags().StringVar(&diffColor, "color", "auto", "Colorize the output: auto, always or never")
+	diffCmd.Flags().StringVar(&diffOpts.Target, "target", "", "Compare the results of these targets of regress.yaml, or \"all\"")
+	diffCmd.Flags().IntVar(&diffOpts.PgMajor, "pg", 0, "Compare with the expected files of this PostgreSQL major version (e.g. 16) rather than the tested one")
+}
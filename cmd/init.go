@@ -15,16 +15,24 @@ var (
 
 // initCmd represents the init command
 var initCmd = &cobra.Command{
-	Use:   "init [flags] postgres://user@host/dbname",
+	Use:   "init [flags] [postgres://user@host/dbname]",
 	Short: "Initialize regresql for use in your project",
-	Args:  cobra.ExactArgs(1),
+	Long: `Initialize regresql for use in your project.
+
+The connection string may be a postgres:// URI or a key=value connection
+string such as 'service=chinook', and may reference environment variables as
+${NAME}. Without a connection string, the PG* environment variables are used.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := checkDirectory(cwd); err != nil {
 			fmt.Printf(err.Error())
 			os.Exit(1)
 		}
-		pguri := args[0]
-		regresql.Init(cwd, pguri)
+		pguri := ""
+		if len(args) > 0 {
+			pguri = args[0]
+		}
+		regresql.Init(cwd, pguri, serviceEnv)
 	},
 }
 
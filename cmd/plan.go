@@ -17,7 +17,7 @@ var planCmd = &cobra.Command{
 			fmt.Printf(err.Error())
 			os.Exit(1)
 		}
-		regresql.PlanQueries(cwd)
+		regresql.PlanQueries(cwd, pguri, serviceEnv)
 	},
 }
 
@@ -34,5 +34,6 @@ func init() {
 	// is called directly, e.g.:
 	// planCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	planCmd.Flags().StringVarP(&cwd, "cwd", "C", ".", "Change to Directory")
+	planCmd.Flags().StringVar(&pguri, "pguri", "", "Connection string to use rather than the pguri of regress.yaml")
 
 }
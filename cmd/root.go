@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/dimitri/regresql/regresql"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -11,6 +12,10 @@ import (
 
 var cfgFile string
 
+// serviceEnv holds the connection service environment variables, which are
+// unset before running the commands, see takeServiceEnv.
+var serviceEnv regresql.ServiceEnv
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "regresql",
@@ -28,7 +33,7 @@ func Execute() {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, takeServiceEnv)
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
@@ -65,3 +70,13 @@ func initConfig() {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
 }
+
+// takeServiceEnv keeps the connection service environment variables in
+// serviceEnv and unsets them, as lib/pq refuses to connect when they are
+// set. The connection strings are resolved with serviceEnv instead.
+func takeServiceEnv() {
+	serviceEnv = regresql.LookupServiceEnv()
+	for _, name := range regresql.ServiceEnvVars {
+		os.Unsetenv(name)
+	}
+}
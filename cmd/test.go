@@ -3,21 +3,70 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/dimitri/regresql/regresql"
 	"github.com/spf13/cobra"
 )
 
+// Command Flags
+var (
+	jobs      int
+	format    string
+	output    string
+	run       string
+	ephemeral bool
+	template  string
+	keep      bool
+	target    string
+	watch     bool
+	timeout   time.Duration
+)
+
 // testCmd represents the test command
 var testCmd = &cobra.Command{
-	Use:   "test [flags]",
+	Use:   "test [flags] [query-file ...]",
 	Short: "Run regression tests for your SQL queries",
+	Long: `Run regression tests for your SQL queries.
+
+When arguments are given, only the selected tests are run. Each argument may
+be a query file, a directory, a glob pattern such as 'src/sql/album-*.sql', or
+a query file followed by a test case name, such as src/sql/artist.sql:red-hot.
+The --run option selects the tests whose name (e.g. src/sql/artist.sql:red-hot)
+matches a regular expression.
+
+With --ephemeral, the tests run in a new database created as a copy of the
+template database, which is dropped at the end unless --keep is given.
+
+With --target, the tests run against the named targets of regress.yaml, given
+as a comma separated list, or all of them with --target all.
+
+With --watch, the tests run again each time a query file, a plan file, or a
+setup or teardown script changes, only for the affected queries, until
+interrupted with Ctrl-C.
+
+With --timeout, a query running longer than the given duration, such as 30s,
+is canceled and its test fails, whatever the timeout setting of regress.yaml
+and of the query files. Ctrl-C cancels the queries in flight and stops the
+tests.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := checkDirectory(cwd); err != nil {
 			fmt.Printf(err.Error())
 			os.Exit(1)
 		}
-		regresql.Test(cwd)
+		regresql.Test(cwd, regresql.Filter{Paths: args, Run: run}, regresql.RunOptions{
+			Jobs:      jobs,
+			Format:    format,
+			Output:    output,
+			Ephemeral: ephemeral,
+			Template:  template,
+			Keep:      keep,
+			Target:    target,
+			PgUri:     pguri,
+			Watch:     watch,
+			Timeout:   timeout,
+			Service:   serviceEnv,
+		})
 	},
 }
 
@@ -34,4 +83,16 @@ func init() {
 	// is called directly, e.g.:
 	// testCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	testCmd.Flags().StringVarP(&cwd, "cwd", "C", ".", "Change to Directory")
+	testCmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "Number of query files to run concurrently")
+	testCmd.Flags().StringVarP(&format, "format", "f", "tap",
+		fmt.Sprintf("Report format, one of %v", regresql.ReportFormats))
+	testCmd.Flags().StringVar(&run, "run", "", "Only run the tests whose name matches this regular expression")
+	testCmd.Flags().BoolVar(&ephemeral, "ephemeral", false, "Run the tests in a new database copied from the template database")
+	testCmd.Flags().StringVar(&template, "template", "", "Template database for --ephemeral, defaults to the pguri database")
+	testCmd.Flags().BoolVar(&keep, "keep", false, "Keep the --ephemeral database at the end of the tests")
+	testCmd.Flags().StringVar(&pguri, "pguri", "", "Connection string to use rather than the pguri of regress.yaml")
+	testCmd.Flags().StringVar(&target, "target", "", "Run the tests against these targets of regress.yaml, or \"all\"")
+	testCmd.Flags().BoolVar(&watch, "watch", false, "Run the affected tests again when files change")
+	testCmd.Flags().DurationVar(&timeout, "timeout", 0, "Cancel the queries running longer than this duration, e.g. 30s")
+	testCmd.Flags().StringVarP(&output, "output", "o", "", "Write the report to this file rather than standard output")
 }
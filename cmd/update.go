@@ -8,7 +8,12 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var versionedAll bool
+// Command Flags
+var (
+	only         []string
+	versioned    bool
+	versionedAll bool
+)
 
 // updateCmd represents the update command
 var updateCmd = &cobra.Command{
@@ -20,8 +25,16 @@ When one or more SQL file paths are given as arguments, only those files
 produce version-specific expected output (e.g. query.pg16.out); all other
 files are updated with generic output as usual.
 
-Use --versioned-all to write version-specific expected files for every query.
---versioned-all and file arguments are mutually exclusive.`,
+Use --versioned to write version-specific expected files for every updated
+query. --versioned and file arguments are mutually exclusive.
+
+Use --only to only update the selected tests. Each of its comma separated
+values may be a query file, a directory, a glob pattern such as
+'src/sql/album-*.sql', or a query file followed by a test case name, such as
+src/sql/artist.sql:red-hot. The --run option selects the tests whose name
+(e.g. src/sql/artist.sql:red-hot) matches a regular expression.
+
+The deprecated --versioned-all option is the same as --versioned.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := checkDirectory(cwd); err != nil {
 			fmt.Printf(err.Error())
@@ -31,7 +44,17 @@ Use --versioned-all to write version-specific expected files for every query.
 			fmt.Println("Error: --versioned-all and file arguments are mutually exclusive")
 			os.Exit(1)
 		}
-		regresql.Update(cwd, args, versionedAll)
+		if versioned && len(args) > 0 {
+			fmt.Println("Error: --versioned and file arguments are mutually exclusive")
+			os.Exit(1)
+		}
+		if versioned || versionedAll {
+			args = []string{"."}
+		}
+		regresql.Update(cwd,
+			regresql.Filter{Paths: only, Run: run},
+			args,
+			regresql.RunOptions{Jobs: jobs, PgUri: pguri, Timeout: timeout, Service: serviceEnv})
 	},
 }
 
@@ -39,6 +62,14 @@ func init() {
 	RootCmd.AddCommand(updateCmd)
 
 	updateCmd.Flags().StringVarP(&cwd, "cwd", "C", ".", "Change to Directory")
+	updateCmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "Number of query files to run concurrently")
+	updateCmd.Flags().StringVar(&pguri, "pguri", "", "Connection string to use rather than the pguri of regress.yaml")
+	updateCmd.Flags().DurationVar(&timeout, "timeout", 0, "Cancel the queries running longer than this duration, e.g. 30s")
+	updateCmd.Flags().StringVar(&run, "run", "", "Only update the tests whose name matches this regular expression")
+	updateCmd.Flags().StringSliceVar(&only, "only", nil, "Only update the tests selected by these paths, globs or file:case names")
+	updateCmd.Flags().BoolVar(&versioned, "versioned", false,
+		"Write version-specific expected files for all the updated queries (e.g. query.pg16.out)")
 	updateCmd.Flags().BoolVar(&versionedAll, "versioned-all", false,
 		"Write version-specific expected files for all queries (e.g. query.pg16.out)")
+	updateCmd.Flags().MarkDeprecated("versioned-all", "use --versioned instead")
 }
@@ -16,3 +16,21 @@ func checkDirectory(cwd string) error {
 	}
 	return nil
 }
+
+// useColor returns whether to colorize the output, given the --color flag
+// value: auto only colorizes when the standard output is a terminal.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		stat, err := os.Stdout.Stat()
+		if err != nil {
+			return false, nil
+		}
+		return stat.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("Invalid --color value '%s', expected auto, always or never", mode)
+}
@@ -3,6 +3,7 @@ module github.com/dimitri/regresql
 go 1.20
 
 require (
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/lib/pq v1.10.9
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/mndrix/tap-go v0.0.0-20171203230836-629fa407e90b
@@ -14,7 +15,6 @@ require (
 )
 
 require (
-	github.com/fsnotify/fsnotify v1.6.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
@@ -5,6 +5,8 @@ Usage:
   regresql [command]
 
 Available Commands:
+  accept      Accept changed results as the new expected ones
+  diff        Show differences between actual and expected results
   help        Help about any command
   init        Initialize regresql for use in your project
   list        list candidates SQL files
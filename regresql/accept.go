@@ -0,0 +1,179 @@
+package regresql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// AcceptOptions are the command line settings of the accept command.
+type AcceptOptions struct {
+	All     bool   // accept every changed result without asking
+	Match   string // only consider results whose name matches this glob
+	Color   bool   // colorize the diffs
+	PgMajor int    // accept into version-specific expected files, when > 0
+	Target  string // comma separated target names, or "all"
+}
+
+// acceptHelp describes the answers to the accept prompt.
+const acceptHelp = `a - accept: copy the actual result to the expected file
+s - skip: keep the expected file as it is
+e - edit: open the expected file in $EDITOR, then ask again
+A - accept this result and all the remaining ones
+q - quit: skip this result and all the remaining ones
+? - print this help
+`
+
+/*
+acceptPairs walks the result pairs whose actual output differs from the
+expected one, shows the diff to out and asks on in what to do with it. When
+opts.All is set, every changed result is accepted without asking. Accepted
+results are copied to the Accept file of their pair. A changed result is
+skipped when another result has already been accepted into the same file,
+as with targets running the same PostgreSQL major version.
+
+It returns the number of accepted results.
+*/
+func acceptPairs(pairs []resultPair, in io.Reader, out io.Writer, opts AcceptOptions) (int, error) {
+	accepted := 0
+	all := opts.All
+	input := bufio.NewReader(in)
+	written := make(map[string]string)
+
+	for _, pair := range pairs {
+		if opts.Match != "" {
+			matched, err := filepath.Match(opts.Match, pair.Name)
+			if err != nil {
+				return accepted, fmt.Errorf("Invalid --match pattern '%s': %s\n",
+					opts.Match, err)
+			}
+			if !matched {
+				continue
+			}
+		}
+
+	prompt:
+		for {
+			expected, actual, ok, err := pair.readLines()
+			if err != nil {
+				return accepted, err
+			}
+			if !ok {
+				break
+			}
+			if changed, err := pair.changed(); err != nil {
+				return accepted, err
+			} else if !changed {
+				break
+			}
+			if name, ok := written[pair.Accept]; ok {
+				fmt.Fprintf(out, "Skipped %s: %s has already been accepted from %s\n",
+					pair.Name, pair.Accept, name)
+				break
+			}
+
+			if all {
+				if err := copyFile(pair.Actual, pair.Accept); err != nil {
+					return accepted, err
+				}
+				fmt.Fprintf(out, "Accepted %s\n", pair.Name)
+				written[pair.Accept] = pair.Name
+				accepted++
+				break
+			}
+
+			diff := DiffLines(pair.Expected, pair.Actual, expected, actual, 3)
+			if opts.Color {
+				diff = ColorizeDiff(diff)
+			}
+			fmt.Fprint(out, diff)
+			fmt.Fprintf(out, "Accept %s? [a,s,e,A,q,?] ", pair.Name)
+
+			answer, err := input.ReadString('\n')
+			if err != nil && answer == "" {
+				if err == io.EOF {
+					fmt.Fprintln(out)
+					return accepted, nil
+				}
+				return accepted, err
+			}
+
+			switch strings.TrimSpace(answer) {
+			case "a":
+				if err := copyFile(pair.Actual, pair.Accept); err != nil {
+					return accepted, err
+				}
+				written[pair.Accept] = pair.Name
+				accepted++
+				break prompt
+			case "A":
+				all = true
+			case "s":
+				break prompt
+			case "e":
+				if err := editFile(pair.Accept, pair.Actual); err != nil {
+					fmt.Fprintln(out, err)
+				}
+				// compare with the edited file from now on
+				pair.Expected = pair.Accept
+			case "q":
+				return accepted, nil
+			default:
+				fmt.Fprint(out, acceptHelp)
+			}
+		}
+	}
+	return accepted, nil
+}
+
+// copyFile copies the src file contents to dst, creating the dst directory
+// when needed.
+func copyFile(src string, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("Failed to read '%s': %s\n", src, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("Failed to create directory '%s': %s\n", filepath.Dir(dst), err)
+	}
+	if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+		return fmt.Errorf("Failed to write '%s': %s\n", dst, err)
+	}
+	return nil
+}
+
+// editFile opens filename in the user's editor, found in $VISUAL or $EDITOR
+// and defaulting to vi. When filename doesn't exist yet it is created as a
+// copy of the template file.
+func editFile(filename string, template string) error {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		if err := copyFile(template, filename); err != nil {
+			return err
+		}
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// $EDITOR may contain arguments, such as "code --wait"
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], filename)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to run editor '%s': %s", editor, err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package regresql
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePairs writes an expected and an actual file for each name, with
+// different contents, and returns the matching result pairs.
+func writePairs(t *testing.T, names ...string) []resultPair {
+	dir, err := ioutil.TempDir("", "regresql-accept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	var pairs []resultPair
+	for _, name := range names {
+		pair := resultPair{
+			Name:     name,
+			Expected: filepath.Join(dir, "expected", name),
+			Actual:   filepath.Join(dir, "out", name),
+		}
+		pair.Accept = pair.Expected
+		if err := os.MkdirAll(filepath.Dir(pair.Actual), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pair.Actual, []byte("new\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(pair.Expected), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pair.Expected, []byte("old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs
+}
+
+func expectedContents(t *testing.T, pair resultPair) string {
+	data, err := ioutil.ReadFile(pair.Expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAcceptPairsPrompt(t *testing.T) {
+	pairs := writePairs(t, "a.1.out", "a.2.out", "a.3.out")
+	var out bytes.Buffer
+
+	n, err := acceptPairs(pairs, strings.NewReader("a\ns\n"), &out, AcceptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 accepted result, got %d", n)
+	}
+	for i, expected := range []string{"new\n", "old\n", "old\n"} {
+		if got := expectedContents(t, pairs[i]); got != expected {
+			t.Errorf("Expected %s to contain %q, got %q", pairs[i].Name, expected, got)
+		}
+	}
+}
+
+func TestAcceptPairsAllMatch(t *testing.T) {
+	pairs := writePairs(t, "a.1.out", "b.1.out", "a.2.out")
+	opts := AcceptOptions{All: true, Match: "a.*.out"}
+
+	n, err := acceptPairs(pairs, strings.NewReader(""), ioutil.Discard, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 accepted results, got %d", n)
+	}
+	if got := expectedContents(t, pairs[1]); got != "old\n" {
+		t.Errorf("Expected %s to be skipped, got %q", pairs[1].Name, got)
+	}
+
+	// accepted results are now unchanged, and not considered again
+	n, err = acceptPairs(pairs, strings.NewReader(""), ioutil.Discard, opts)
+	if err != nil || n != 0 {
+		t.Errorf("Expected no accepted result, got %d (%v)", n, err)
+	}
+}
+
+func TestAcceptPairsVersioned(t *testing.T) {
+	pairs := writePairs(t, "a.1.out")
+	pairs[0].Accept = filepath.Join(filepath.Dir(pairs[0].Expected), "a.1.pg16.out")
+
+	n, err := acceptPairs(pairs, strings.NewReader(""), ioutil.Discard, AcceptOptions{All: true})
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 accepted result, got %d (%v)", n, err)
+	}
+	if got := expectedContents(t, pairs[0]); got != "old\n" {
+		t.Errorf("Expected the generic expected file to be left alone, got %q", got)
+	}
+	data, err := ioutil.ReadFile(pairs[0].Accept)
+	if err != nil || string(data) != "new\n" {
+		t.Errorf("Expected the version-specific expected file to be created, got %q (%v)", data, err)
+	}
+}
+
+func TestAcceptPairsTolerance(t *testing.T) {
+	pairs := writePairs(t, "a.1.out")
+	for filename, contents := range map[string]string{
+		pairs[0].Expected: "avg\n----\n0.3\n",
+		pairs[0].Actual:   "    avg\n-----------\n0.300000001\n",
+	} {
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pairs[0].Options.Tolerance = []Tolerance{{Rel: 1e-6}}
+
+	n, err := acceptPairs(pairs, strings.NewReader(""), ioutil.Discard, AcceptOptions{All: true})
+	if err != nil || n != 0 {
+		t.Errorf("Expected a result within tolerance not to be accepted, got %d (%v)", n, err)
+	}
+}
+
+func TestAcceptPairsSameFile(t *testing.T) {
+	pairs := writePairs(t, "pg13/a.1.out", "pg14/a.1.out")
+	pairs[1].Expected, pairs[1].Accept = pairs[0].Expected, pairs[0].Accept
+	if err := ioutil.WriteFile(pairs[1].Actual, []byte("newer\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+
+	n, err := acceptPairs(pairs, strings.NewReader(""), &out, AcceptOptions{All: true})
+	if err != nil || n != 1 {
+		t.Fatalf("Expected 1 accepted result, got %d (%v)", n, err)
+	}
+	if got := expectedContents(t, pairs[0]); got != "new\n" {
+		t.Errorf("Expected the first result to be kept, got %q", got)
+	}
+	if !strings.Contains(out.String(), "Skipped pg14/a.1.out") {
+		t.Errorf("Expected the second result to be skipped, got:\n%s", out.String())
+	}
+}
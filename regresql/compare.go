@@ -0,0 +1,180 @@
+package regresql
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+CompareResultsSets load the expected result set and compares it with the
+given Plan's ResultSet, and reports a TestResult for each of them to r.
+
+The test is considered passed when the diff is empty. When the Plan has the
+Unordered option set, the rows order is not taken into account, and numbers
+are compared with the Tolerance rules of the Plan options. Structured
+result files (json, csv) are compared cell by cell, and the diff then is a
+report of the changed cells.
+
+The duration of each binding is checked against its baseline, recorded at
+update time, following the Plan Timing option: slow tests are reported,
+and fail when the Timing action is "fail".
+
+A binding expected to fail, see ExpectedError, fails the test when it
+doesn't fail with the expected error, whatever the diff.
+
+When the Explain option is set, the query plan of each binding is compared
+with its expected plan file too, and reported as a separate test.
+
+When pgMajor > 0, a version-specific expected file (e.g. query.pg16.out) is
+checked first; the generic file (query.out) is used as fallback. The same
+goes for plan files (e.g. query.pg16.explain).
+
+A binding that failed to run, or which results can't be compared, is
+reported as an errored test, with the error message. A binding canceled
+after the Timeout option is reported as a timed out test, which counts as a
+failure rather than an error.
+
+Rather than returning an error in case something wrong happens, we register
+the error in the TestResult and let the Reporter output a diagnostic.
+*/
+// CompareResultSets compares each result set in the plan against its expected
+// file and reports the results.  It returns the number of failing tests, and
+// of errored tests, so the caller can propagate a non-zero exit code.
+func (p *Plan) CompareResultSets(regressDir string, expectedDir string, r Reporter, pgMajor int) (failed int, errored int) {
+	baselines, timingErr := readTimings(p, expectedDir)
+	report := func(result TestResult) {
+		switch {
+		case result.Errored:
+			errored++
+		case !result.Passed:
+			failed++
+		}
+		r.Report(result)
+	}
+
+	for i, rs := range p.ResultSets {
+		testName := strings.TrimPrefix(rs.Filename, regressDir+"/out/")
+		expectedFilename := expectedFile(expectedDir, filepath.Base(rs.Filename), pgMajor)
+
+		// p.Names and p.Bindings are empty for parameterless queries; guard
+		// against an out-of-range panic.
+		bindingName := ""
+		if i < len(p.Names) {
+			bindingName = p.Names[i]
+		}
+		var bindingParams interface{} = map[string]interface{}{}
+		if i < len(p.Bindings) {
+			bindingParams = p.Bindings[i]
+		}
+
+		result := TestResult{
+			Name:         testName,
+			QueryFile:    p.Query.Path,
+			PlanFile:     p.Path,
+			Binding:      bindingName,
+			Params:       bindingParams,
+			ExpectedFile: expectedFilename,
+			ActualFile:   rs.Filename,
+		}
+		if rs.Error != "" {
+			result.TimedOut = rs.TimedOut
+			result.Errored = !rs.TimedOut
+			result.Error = rs.Error
+			report(result)
+			continue
+		}
+
+		diff, err := compareFiles(expectedFilename, rs.Filename, p.Options)
+
+		result.Passed = diff == "" && err == nil && timingErr == nil
+		result.Diff = diff
+		result.Duration = rs.Duration
+		result.Baseline = baselines[timingKey(p, i)]
+
+		if err != nil {
+			result.Error = err.Error()
+		} else if timingErr != nil {
+			result.Error = timingErr.Error()
+		}
+		result.Errored = result.Error != ""
+
+		result.Slow = p.Options.Timing.check(result.Duration, result.Baseline)
+		if result.Slow != "" && p.Options.Timing.fail() {
+			result.Passed = false
+		}
+		if rs.Failure != "" && !result.Errored {
+			result.Passed = false
+			result.Error = rs.Failure
+		}
+		report(result)
+
+		if rs.Explain != "" {
+			report(p.compareExplain(rs, result, regressDir, expectedDir, pgMajor))
+		}
+	}
+	return failed, errored
+}
+
+// compareExplain compares the query plan of rs with its expected plan file
+// and reports the outcome as a test of its own, named after the plan file,
+// with the binding details of the result set test.
+func (p *Plan) compareExplain(rs ResultSet, result TestResult,
+	regressDir string, expectedDir string, pgMajor int) TestResult {
+
+	actual := explainPath(rs.Filename)
+	expected := expectedFile(expectedDir, filepath.Base(actual), pgMajor)
+	diff, err := compareFiles(expected, actual, Options{})
+
+	result.Name = strings.TrimPrefix(actual, regressDir+"/out/")
+	result.ExpectedFile = expected
+	result.ActualFile = actual
+	result.Passed = diff == "" && err == nil
+	result.Errored = err != nil
+	result.Diff = diff
+	result.Error = ""
+	result.Duration = 0
+	result.Baseline = 0
+	result.Slow = ""
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// compareFiles compares the expected and actual result files following the
+// options o, and returns the diff, empty when the results are the same.
+// Structured result files are compared cell by cell, the other ones line by
+// line, see CompareTables and DiffFilesOptions.
+func compareFiles(expected string, actual string, o Options) (string, error) {
+	switch filepath.Ext(actual) {
+	case ".json", ".csv":
+		return CompareTables(expected, actual, o)
+	}
+	return DiffFilesOptions(expected, actual, 3, o)
+}
+
+// expectedFile returns the path of the expected file for the result file
+// base in expectedDir. When pgMajor > 0 and a version-specific expected file
+// (e.g. query.pg16.out) exists, it is used rather than the generic one.
+func expectedFile(expectedDir string, base string, pgMajor int) string {
+	if pgMajor > 0 {
+		versioned := versionedFile(expectedDir, base, pgMajor)
+		if _, err := os.Stat(versioned); err == nil {
+			return versioned
+		}
+	}
+	return filepath.Join(expectedDir, base)
+}
+
+// versionedFile returns the path of the version-specific expected file for
+// the result file base in expectedDir, e.g. query.pg16.out, or the generic
+// one when pgMajor is 0, whether the file exists or not.
+func versionedFile(expectedDir string, base string, pgMajor int) string {
+	if pgMajor > 0 {
+		ext := filepath.Ext(base)
+		base = fmt.Sprintf("%s.pg%d%s", strings.TrimSuffix(base, ext), pgMajor, ext)
+	}
+	return filepath.Join(expectedDir, base)
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
 
 	// "github.com/spf13/viper"
 	"github.com/theherk/viper" // fork with write support
@@ -14,10 +15,25 @@ import (
 // Config structure is useful to store the PostgreSQL connection string, and
 // also remember the code root directory, which as of now is always either
 // ./ or the -C command line parameter.
+//
+// The default Options for running the queries are read from the top level
+// of the configuration file too, e.g. "rollback: true".
+//
+// Setup and Teardown are SQL scripts, relative to the code root directory,
+// run before and after the queries. Template is the database copied for
+// ephemeral test runs, it defaults to the PgUri database.
+//
+// Targets are named connection strings of other databases to run the tests
+// against, such as one per supported PostgreSQL version.
 type config struct {
-	Root    string
-	PgUri   string
-	Exclude []string
+	Root     string
+	PgUri    string
+	Exclude  []string
+	Setup    []string
+	Teardown []string
+	Template string
+	Targets  map[string]string
+	Options  `mapstructure:",squash"`
 }
 
 func (s *Suite) getRegressConfigFile() string {
@@ -64,8 +80,55 @@ func (s *Suite) readConfig() (config, error) {
 			err)
 	}
 
-	v.ReadConfig(bytes.NewBuffer(data))
-	v.Unmarshal(&config)
+	if err := v.ReadConfig(bytes.NewBuffer(data)); err != nil {
+		return config, fmt.Errorf("Failed to read config '%s': %s",
+			configFile,
+			err)
+	}
+
+	// the timeout setting has the syntax of the timeout directive
+	if v.IsSet("timeout") {
+		d, err := parseTimeout([]string{fmt.Sprint(v.Get("timeout"))})
+		if err != nil {
+			return config, fmt.Errorf("Failed to read config '%s': %s",
+				configFile,
+				err)
+		}
+		v.Set("timeout", d)
+	}
+
+	if err := v.Unmarshal(&config); err != nil {
+		return config, fmt.Errorf("Failed to read config '%s': %s",
+			configFile,
+			err)
+	}
+
+	if err := checkResultFormat(config.Format); err != nil {
+		return config, fmt.Errorf("Failed to read config '%s': %s",
+			configFile,
+			err)
+	}
+
+	for _, m := range config.Mask {
+		if _, err := regexp.Compile(m.Pattern); err != nil {
+			return config, fmt.Errorf("Failed to read config '%s': invalid mask pattern %q: %s",
+				configFile,
+				m.Pattern,
+				err)
+		}
+	}
+
+	if _, ok := config.Targets[allTargets]; ok {
+		return config, fmt.Errorf("Failed to read config '%s': target name %q is reserved",
+			configFile,
+			allTargets)
+	}
+
+	if a := config.Timing.Action; a != "" && a != "warn" && a != "fail" {
+		return config, fmt.Errorf("Failed to read config '%s': invalid timing action %q, expected warn or fail",
+			configFile,
+			a)
+	}
 
 	return config, nil
 }
@@ -0,0 +1,38 @@
+package regresql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeConfig creates a regresql/regress.yaml file with the given contents
+// in a temporary directory and returns the Suite for that directory.
+func writeConfig(t *testing.T, contents string) *Suite {
+	t.Helper()
+	root := t.TempDir()
+	s := newSuite(root)
+	if err := os.Mkdir(s.RegressDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.RegressDir, "regress.yaml"), []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestReadConfigOptions(t *testing.T) {
+	s := writeConfig(t, "pguri: postgres:///db\nrollback: true\n")
+
+	config, err := s.readConfig()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if config.PgUri != "postgres:///db" {
+		t.Errorf("Expected PgUri==\"postgres:///db\", got %q", config.PgUri)
+	}
+	if !config.Rollback {
+		t.Error("Expected Rollback==true from regress.yaml")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/pmezard/go-difflib/difflib"
 	"io/ioutil"
+	"strings"
+	"unicode/utf8"
 )
 
 //readLines reads filename contents and returns a list of strings
@@ -50,3 +52,156 @@ func DiffLines(from string, to string, a []string, b []string, c int) string {
 	text, _ := difflib.GetUnifiedDiffString(diff)
 	return text
 }
+
+// ANSI escape sequences used to colorize diffs
+const (
+	colorReset = "\x1b[0m"
+	colorBold  = "\x1b[1m"
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan  = "\x1b[36m"
+)
+
+// ColorizeDiff adds ANSI colors to a unified diff, in the same way as git
+// does: removed lines in red, added lines in green, hunk headers in cyan.
+func ColorizeDiff(diff string) string {
+	var b strings.Builder
+
+	for _, line := range difflib.SplitLines(diff) {
+		text := strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(text, "--- ") || strings.HasPrefix(text, "+++ "):
+			b.WriteString(colorBold + text + colorReset)
+		case strings.HasPrefix(text, "@@"):
+			b.WriteString(colorCyan + text + colorReset)
+		case strings.HasPrefix(text, "-"):
+			b.WriteString(colorRed + text + colorReset)
+		case strings.HasPrefix(text, "+"):
+			b.WriteString(colorGreen + text + colorReset)
+		default:
+			b.WriteString(text)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// SideBySide compares two lists of strings and reports differences in two
+// columns, as `diff --side-by-side` does: the gutter is "|" for changed
+// lines, "<" for lines only in a and ">" for lines only in b. The output
+// fits in width characters, and is colorized when color is true.
+func SideBySide(a []string, b []string, width int, color bool) string {
+	var out strings.Builder
+	col := (width - 3) / 2
+	if col < 10 {
+		col = 10
+	}
+
+	line := func(left, gutter, right string) {
+		left = fitColumn(left, col)
+		right = strings.TrimSuffix(right, "\n")
+		if utf8.RuneCountInString(right) > col {
+			right = fitColumn(right, col)
+		}
+		if color {
+			switch gutter {
+			case "|":
+				left, right = colorRed+left+colorReset, colorGreen+right+colorReset
+			case "<":
+				left = colorRed + left + colorReset
+			case ">":
+				right = colorGreen + right + colorReset
+			}
+		}
+		text := fmt.Sprintf("%s %s %s", left, gutter, right)
+		out.WriteString(strings.TrimRight(text, " ") + "\n")
+	}
+
+	m := difflib.NewMatcher(a, b)
+	for _, op := range m.GetOpCodes() {
+		switch op.Tag {
+		case 'e':
+			for i := op.I1; i < op.I2; i++ {
+				line(a[i], " ", b[op.J1+i-op.I1])
+			}
+		case 'd':
+			for i := op.I1; i < op.I2; i++ {
+				line(a[i], "<", "")
+			}
+		case 'i':
+			for j := op.J1; j < op.J2; j++ {
+				line("", ">", b[j])
+			}
+		case 'r':
+			i, j := op.I1, op.J1
+			for ; i < op.I2 && j < op.J2; i, j = i+1, j+1 {
+				line(a[i], "|", b[j])
+			}
+			for ; i < op.I2; i++ {
+				line(a[i], "<", "")
+			}
+			for ; j < op.J2; j++ {
+				line("", ">", b[j])
+			}
+		}
+	}
+	return out.String()
+}
+
+// fitColumn returns s without its trailing newline, truncated or padded with
+// spaces to exactly width runes.
+func fitColumn(s string, width int) string {
+	r := []rune(strings.TrimSuffix(s, "\n"))
+	if len(r) > width {
+		return string(r[:width])
+	}
+	return string(r) + strings.Repeat(" ", width-len(r))
+}
+
+// DiffStat returns the number of lines added to and removed from a to get b.
+func DiffStat(a []string, b []string) (insertions int, deletions int) {
+	m := difflib.NewMatcher(a, b)
+	for _, op := range m.GetOpCodes() {
+		switch op.Tag {
+		case 'd':
+			deletions += op.I2 - op.I1
+		case 'i':
+			insertions += op.J2 - op.J1
+		case 'r':
+			deletions += op.I2 - op.I1
+			insertions += op.J2 - op.J1
+		}
+	}
+	return insertions, deletions
+}
+
+// formatDiffStat formats the changes counts of each file in names as
+// `git diff --stat` does, with a histogram of at most 40 characters.
+func formatDiffStat(names []string, counts [][2]int, color bool) string {
+	var b strings.Builder
+
+	nameWidth, maxChanges := 0, 0
+	for i, name := range names {
+		if len(name) > nameWidth {
+			nameWidth = len(name)
+		}
+		if n := counts[i][0] + counts[i][1]; n > maxChanges {
+			maxChanges = n
+		}
+	}
+
+	for i, name := range names {
+		ins, del := counts[i][0], counts[i][1]
+		if maxChanges > 40 {
+			ins = (ins*40 + maxChanges - 1) / maxChanges
+			del = (del*40 + maxChanges - 1) / maxChanges
+		}
+		plus, minus := strings.Repeat("+", ins), strings.Repeat("-", del)
+		if color {
+			plus, minus = colorGreen+plus+colorReset, colorRed+minus+colorReset
+		}
+		fmt.Fprintf(&b, " %-*s | %d %s%s\n",
+			nameWidth, name, counts[i][0]+counts[i][1], plus, minus)
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package regresql
+
+import (
+	"testing"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+func TestDiffStat(t *testing.T) {
+	a := difflib.SplitLines(" a | b\n---+---\n 1 | x\n 2 | y")
+	b := difflib.SplitLines(" a | b\n---+---\n 1 | x\n 2 | z\n 3 | w")
+
+	ins, del := DiffStat(a, b)
+	if ins != 2 || del != 1 {
+		t.Errorf("Expected 2 insertions and 1 deletion, got %d and %d", ins, del)
+	}
+}
+
+func TestSideBySide(t *testing.T) {
+	a := []string{"same\n", "old\n", "gone\n"}
+	b := []string{"same\n", "new\n"}
+
+	// two columns of 10 characters, separated by the gutter
+	expected := "same         same\n" +
+		"old        | new\n" +
+		"gone       <\n"
+	if out := SideBySide(a, b, 23, false); out != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
@@ -62,8 +62,9 @@ the file to fill in "Red Hot Chili Peppers" from an empty string, as created
 by the Init() function.
 
 The Init() function created a YAML plan file for each query, using the
-https://github.com/spf13/viper library. The user is expected to edit the
-YAML files. Once the parameters are edited it's possible to run the queries.
+https://gopkg.in/yaml.v2 library. The user is expected to edit the YAML
+files. Once the parameters are edited it's possible to run the queries, in
+the order the test cases appear in the plan file.
 
 Update() runs the queries and stores their results in an expected file.
 
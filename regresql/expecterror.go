@@ -0,0 +1,181 @@
+package regresql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	"gopkg.in/yaml.v2"
+)
+
+// expectErrorKey is the name of the directive, and of the test case key in
+// plan files, declaring that a query must fail.
+const expectErrorKey = "expect_error"
+
+/*
+An ExpectedError declares that a query must fail, with the given SQLSTATE
+error code and an error message matching the Message regular expression,
+either of them being optional:
+
+	-- regresql: expect_error 23505
+
+	"duplicate":
+	  name: AC/DC
+	  expect_error:
+	    sqlstate: 23505
+	    message: duplicate key
+
+The error is then written in the result file in place of the result set,
+and compared with the expected one as usual.
+*/
+type ExpectedError struct {
+	SQLState string `yaml:"sqlstate,omitempty" json:"sqlstate,omitempty"`
+	Message  string `yaml:"message,omitempty" json:"message,omitempty"`
+}
+
+// parseExpectedError parses the expect_error directive arguments: a bare
+// argument is the SQLSTATE code, and sqlstate=code and message=pattern
+// arguments are accepted too.
+func parseExpectedError(args []string) (*ExpectedError, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s expects a SQLSTATE code or a message pattern", expectErrorKey)
+	}
+
+	var e ExpectedError
+	for _, arg := range args {
+		if !strings.Contains(arg, "=") {
+			e.SQLState = arg
+			continue
+		}
+		kv, err := splitKeyValue(expectErrorKey, arg)
+		if err != nil {
+			return nil, err
+		}
+		switch kv[0] {
+		case "sqlstate":
+			e.SQLState = kv[1]
+		case "message":
+			e.Message = kv[1]
+		default:
+			return nil, fmt.Errorf("%s: unknown key %q", expectErrorKey, kv[0])
+		}
+	}
+
+	if e.SQLState != "" && len(e.SQLState) != 5 {
+		return nil, fmt.Errorf("%s: invalid SQLSTATE code %q", expectErrorKey, e.SQLState)
+	}
+	if _, err := regexp.Compile(e.Message); err != nil {
+		return nil, fmt.Errorf("%s: invalid message pattern '%s': %s", expectErrorKey, e.Message, err)
+	}
+	return &e, nil
+}
+
+// planExpectedError parses the expect_error value of a plan test case,
+// either a SQLSTATE code or a mapping of the directive arguments.
+func planExpectedError(v interface{}) (*ExpectedError, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case yaml.MapSlice:
+		var args []string
+		for _, kv := range v {
+			args = append(args, fmt.Sprintf("%v=%v", kv.Key, kv.Value))
+		}
+		return parseExpectedError(args)
+	}
+	return parseExpectedError([]string{fmt.Sprintf("%v", v)})
+}
+
+func (e *ExpectedError) String() string {
+	switch {
+	case e.Message == "":
+		return e.SQLState
+	case e.SQLState == "":
+		return fmt.Sprintf("matching '%s'", e.Message)
+	}
+	return fmt.Sprintf("%s matching '%s'", e.SQLState, e.Message)
+}
+
+// check returns why err isn't the expected error, or an empty string.
+func (e *ExpectedError) check(err *pq.Error) string {
+	if (e.SQLState != "" && string(err.Code) != e.SQLState) ||
+		(e.Message != "" && !regexp.MustCompile(e.Message).MatchString(err.Message)) {
+		return fmt.Sprintf("Expected error %s, got %s: %s", e, err.Code, err.Message)
+	}
+	return ""
+}
+
+// expectedError returns the error expected from the i-th binding of the
+// Plan, if any, given either in the test case or with the Plan options.
+func (p *Plan) expectedError(i int) *ExpectedError {
+	if i < len(p.Bindings) {
+		if e, ok := p.Bindings[i][expectErrorKey].(*ExpectedError); ok {
+			return e
+		}
+	}
+	return p.Options.ExpectError
+}
+
+// run runs query with args against db, see query. When the query is
+// expected to fail with expect and does, its error is returned as a
+// ResultSet, and the ResultSet Failure is set when the outcome of the query
+// isn't the expected one. Only the errors of the query statement itself
+// are checked against expect: failing to connect or to set the session up
+// is returned as an error.
+func (p *Plan) run(ctx context.Context, db *sql.DB, expect *ExpectedError, query string, args ...interface{}) (*ResultSet, error) {
+	res, err := p.query(ctx, db, query, args...)
+	if expect == nil {
+		return res, err
+	}
+	if err == nil {
+		res.Failure = fmt.Sprintf("Expected error %s, but the query succeeded", expect)
+		return res, nil
+	}
+
+	pqErr := statementError(err)
+	if pqErr == nil {
+		return nil, err
+	}
+	res = errorResultSet(pqErr)
+	res.Failure = expect.check(pqErr)
+	return res, nil
+}
+
+// A queryError is an error of the query statement itself, rather than of
+// the connection or of the session setup, see Plan.query.
+type queryError struct {
+	err error
+}
+
+func (e *queryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *queryError) Unwrap() error {
+	return e.err
+}
+
+// statementError returns the PostgreSQL error of the query statement when
+// err is one, and nil otherwise.
+func statementError(err error) *pq.Error {
+	var qErr *queryError
+	var pqErr *pq.Error
+	if !errors.As(err, &qErr) || !errors.As(qErr.err, &pqErr) {
+		return nil
+	}
+	return pqErr
+}
+
+// errorResultSet returns a ResultSet with the SQLSTATE code and the message
+// of err, so that the error is written and compared as a query result.
+func errorResultSet(err *pq.Error) *ResultSet {
+	return &ResultSet{
+		Cols:  []string{"sqlstate", "message"},
+		Types: []string{"text", "text"},
+		Rows:  [][]interface{}{{string(err.Code), err.Message}},
+	}
+}
@@ -0,0 +1,158 @@
+package regresql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestParseExpectedError(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected ExpectedError
+	}{
+		{[]string{"22P02"}, ExpectedError{SQLState: "22P02"}},
+		{[]string{"sqlstate=23505", "message=duplicate key"}, ExpectedError{"23505", "duplicate key"}},
+		{[]string{"message=^permission denied"}, ExpectedError{Message: "^permission denied"}},
+	}
+	for _, test := range tests {
+		e, err := parseExpectedError(test.args)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", test.args, err)
+			continue
+		}
+		if *e != test.expected {
+			t.Errorf("%v: expected %+v, got %+v", test.args, test.expected, *e)
+		}
+	}
+
+	for _, args := range [][]string{nil, {"2350"}, {"message=("}, {"code=23505"}} {
+		if _, err := parseExpectedError(args); err == nil {
+			t.Errorf("%v: expected an error, got nil", args)
+		}
+	}
+}
+
+func TestPlanExpectedError(t *testing.T) {
+	data := []byte(`"bad-input":
+  id: abc
+  expect_error: 22P02
+"duplicate":
+  id: 1
+  expect_error:
+    sqlstate: 23505
+    message: duplicate key
+"ok":
+  id: 2
+`)
+	_, bindings, err := parsePlan(data)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	q := mustParseQueryString(t, "src/sql/q.sql", "-- regresql: expect_error 23502\nSELECT :id::int;\n")
+	p := &Plan{Query: q, Bindings: bindings}
+	if err := p.SetOptions(Options{}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expected := []string{"22P02", "23505 matching 'duplicate key'", "23502"}
+	for i, s := range expected {
+		if e := p.expectedError(i); e == nil || e.String() != s {
+			t.Errorf("Expected binding %d to expect error %s, got %v", i, s, e)
+		}
+	}
+
+	_, args, err := q.Prepare(bindings[0])
+	if err != nil || len(args) != 1 || args[0] != "abc" {
+		t.Errorf("Expected expect_error not to be bound, got %v (%v)", args, err)
+	}
+}
+
+func TestExpectedErrorCheck(t *testing.T) {
+	err := &pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "artist_pkey"`}
+
+	tests := []struct {
+		e  ExpectedError
+		ok bool
+	}{
+		{ExpectedError{SQLState: "23505"}, true},
+		{ExpectedError{Message: "^duplicate key"}, true},
+		{ExpectedError{"23505", "artist_pkey"}, true},
+		{ExpectedError{SQLState: "23502"}, false},
+		{ExpectedError{"23505", "album_pkey"}, false},
+	}
+	for _, test := range tests {
+		if failure := test.e.check(err); (failure == "") != test.ok {
+			t.Errorf("%s: expected ok=%v, got %q", &test.e, test.ok, failure)
+		}
+	}
+}
+
+func TestStatementError(t *testing.T) {
+	err := &pq.Error{Code: "42704", Message: `role "reader" does not exist`}
+
+	if got := statementError(&queryError{err}); got != err {
+		t.Errorf("Expected the query statement error, got %v", got)
+	}
+	for _, other := range []error{
+		err,
+		fmt.Errorf("Failed to set role 'reader': %w", err),
+		errors.New("connection refused"),
+	} {
+		if got := statementError(other); got != nil {
+			t.Errorf("Expected %q not to be a query statement error, got %v", other, got)
+		}
+	}
+}
+
+func TestCompareExpectedError(t *testing.T) {
+	dir := t.TempDir()
+	q := mustParseQueryString(t, "src/sql/artist.sql", "INSERT INTO artist VALUES (1);\n")
+
+	rs := errorResultSet(&pq.Error{Code: "23505", Message: "duplicate key value"})
+	rs.Filename = filepath.Join(dir, "out", "artist.out")
+	rs.Failure = "Expected error 23502, got 23505: duplicate key value"
+
+	os.MkdirAll(filepath.Join(dir, "out"), 0755)
+	for _, filename := range []string{rs.Filename, filepath.Join(dir, "artist.out")} {
+		if err := rs.Write(filename, true); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	data, err := ioutil.ReadFile(rs.Filename)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !strings.Contains(string(data), "23505    | duplicate key value") {
+		t.Errorf("Expected the error in the result file, got:\n%s", data)
+	}
+
+	var b bytes.Buffer
+	r, _ := NewReporter("json", &b)
+	p := &Plan{Query: q, Path: "regresql/plans/src/sql/artist.yaml",
+		ResultSets: []ResultSet{*rs}}
+
+	if failures, _ := p.CompareResultSets(dir, dir, r, 0); failures != 1 {
+		t.Errorf("Expected 1 failure, got %d", failures)
+	}
+	if err := r.Finish(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(b.Bytes(), &report); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if result := report.Results[0]; result.Status != "failed" || result.Error != rs.Failure {
+		t.Errorf("Expected a failed test with the error mismatch, got %+v", result)
+	}
+}
@@ -0,0 +1,94 @@
+package regresql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// explainExt is the extension of the files where the query plans are
+// stored, next to the result files.
+const explainExt = ".explain"
+
+// explainNoise lists the EXPLAIN output keys that are dropped from the
+// stored plans, as they depend on the PostgreSQL version or settings rather
+// than on the plan itself.
+var explainNoise = map[string]bool{
+	"Parallel Aware": true,
+	"Async Capable":  true,
+	"Disabled":       true,
+}
+
+// explainPath returns the path of the plan file that goes with the result
+// file filename, e.g. artist.1.explain for artist.1.out.
+func explainPath(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + explainExt
+}
+
+// explainQuery returns the normalized plan of running query with args on
+// conn, as given by EXPLAIN (FORMAT JSON, COSTS OFF). The query is planned
+// but not executed.
+func explainQuery(ctx context.Context, conn *sql.Conn, query string, args ...interface{}) (string, error) {
+	var plan []byte
+
+	explain := "EXPLAIN (FORMAT JSON, COSTS OFF)\n" + query
+	if err := conn.QueryRowContext(ctx, explain, args...).Scan(&plan); err != nil {
+		return "", err
+	}
+	return normalizePlan(plan)
+}
+
+/*
+normalizePlan returns the EXPLAIN (FORMAT JSON) output plan in a stable
+form, so that plan files can be compared line by line: keys are sorted, a
+key is output per line, and the keys listed in explainNoise are removed.
+*/
+func normalizePlan(plan []byte) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(plan, &v); err != nil {
+		return "", fmt.Errorf("Failed to parse query plan: %s", err)
+	}
+	// keep conditions such as (id > $1) readable
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(dropNoise(v)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// dropNoise removes the explainNoise keys from the parsed plan v.
+func dropNoise(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if explainNoise[key] {
+				delete(v, key)
+				continue
+			}
+			v[key] = dropNoise(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = dropNoise(value)
+		}
+	}
+	return v
+}
+
+// writeExplain writes the plan of the result set rs in the plan file that
+// goes with its result file filename.
+func (rs ResultSet) writeExplain(filename string) error {
+	filename = explainPath(filename)
+	if err := ioutil.WriteFile(filename, []byte(rs.Explain), 0644); err != nil {
+		return fmt.Errorf("Failed to write query plan '%s': %s\n", filename, err)
+	}
+	return nil
+}
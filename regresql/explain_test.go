@@ -0,0 +1,97 @@
+package regresql
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizePlan(t *testing.T) {
+	plan := `[{"Plan": {"Node Type": "Index Scan", "Parallel Aware": false,
+"Async Capable": false, "Index Name": "artist_pkey", "Relation Name": "artist",
+"Index Cond": "(artistid > $1)"}}]`
+
+	got, err := normalizePlan([]byte(plan))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := `[
+  {
+    "Plan": {
+      "Index Cond": "(artistid > $1)",
+      "Index Name": "artist_pkey",
+      "Node Type": "Index Scan",
+      "Relation Name": "artist"
+    }
+  }
+]
+`
+	if got != expected {
+		t.Errorf("Expected normalized plan:\n%s\ngot:\n%s", expected, got)
+	}
+
+	if _, err := normalizePlan([]byte("Seq Scan on artist")); err == nil {
+		t.Error("Expected an error for a plan that is not JSON, got nil")
+	}
+}
+
+func TestExplainPath(t *testing.T) {
+	for filename, expected := range map[string]string{
+		"out/artist.1.out":       "out/artist.1.explain",
+		"out/artist.1.pg16.json": "out/artist.1.pg16.explain",
+	} {
+		if got := explainPath(filename); got != expected {
+			t.Errorf("Expected explainPath(%q) == %q, got %q", filename, expected, got)
+		}
+	}
+}
+
+func TestCompareResultSetsExplain(t *testing.T) {
+	dir := t.TempDir()
+	q := mustParseQueryString(t, "src/sql/artist.sql", "SELECT 1;\n")
+	rs := ResultSet{
+		Cols:    []string{"?column?"},
+		Rows:    [][]interface{}{{int64(1)}},
+		Explain: "[\n  {\n    \"Node Type\": \"Index Scan\"\n  }\n]\n",
+	}
+	p := &Plan{Query: q, Path: "regresql/plans/src/sql/artist.yaml",
+		ResultSets: []ResultSet{rs}, Options: Options{Explain: true}}
+
+	os.MkdirAll(filepath.Join(dir, "out"), 0755)
+	if err := p.WriteResultSets(dir, 16); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "artist.pg16.explain")); err != nil {
+		t.Fatal("Expected a version-specific plan file:", err)
+	}
+
+	p.ResultSets[0].Explain = "[\n  {\n    \"Node Type\": \"Seq Scan\"\n  }\n]\n"
+	if err := p.WriteResultSets(filepath.Join(dir, "out"), 0); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	var b bytes.Buffer
+	r, _ := NewReporter("json", &b)
+	if failures, _ := p.CompareResultSets(dir, dir, r, 16); failures != 1 {
+		t.Errorf("Expected 1 failure, got %d", failures)
+	}
+	if err := r.Finish(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(b.Bytes(), &report); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(report.Results) != 2 {
+		t.Fatalf("Expected a result set test and a plan test, got %+v", report.Results)
+	}
+	explain := report.Results[1]
+	if explain.Name != "artist.explain" || explain.Status != "failed" ||
+		explain.ExpectedFile != filepath.Join(dir, "artist.pg16.explain") {
+		t.Errorf("Expected a failed plan test against artist.pg16.explain, got %+v", explain)
+	}
+}
@@ -0,0 +1,278 @@
+package regresql
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/*
+A Filter selects the tests to run in a Suite. Paths are relative to the Suite
+root and each of them may be:
+
+  - a query file, such as src/sql/artist.sql,
+  - a directory, selecting every query file in its subtree,
+  - a glob pattern, such as 'src/sql/album-*.sql',
+  - any of the above followed by a test case name from the plan file, such
+    as src/sql/artist.sql:red-hot, or for files holding several named
+    queries by a query name, possibly followed by a test case name, such as
+    src/sql/albums.sql:list-albums-by-artist:1.
+
+Run is a regular expression matched against the test names, formed of the
+query file path and the test case name, as in src/sql/artist.sql:red-hot,
+or only the query file path for queries without parameters. Test case names
+are prefixed with their query name in files holding several named queries.
+
+An empty Filter selects every test in the Suite.
+*/
+type Filter struct {
+	Paths []string
+	Run   string
+}
+
+// A pathFilter is a parsed Filter path, with its test case name if any.
+type pathFilter struct {
+	pattern string
+	name    string
+}
+
+// parsePathFilter splits p into a query file pattern and a test case name.
+func parsePathFilter(p string) pathFilter {
+	if i := strings.Index(p, ".sql:"); i >= 0 {
+		return pathFilter{filepath.Clean(p[:i+4]), p[i+5:]}
+	}
+	return pathFilter{filepath.Clean(p), ""}
+}
+
+// match returns true when the query file relPath is selected by the pattern:
+// either the file itself, a directory containing it, or a glob pattern
+// matching the file or one of its directories.
+func (f pathFilter) match(relPath string) bool {
+	if f.pattern == "." {
+		return true
+	}
+	for path := relPath; path != "." && path != "/"; path = filepath.Dir(path) {
+		if path == f.pattern {
+			return true
+		}
+		if matched, _ := filepath.Match(f.pattern, path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// testName returns the name of the test case used to match Filter.Run.
+func testName(relPath string, name string) string {
+	if name == "" {
+		return relPath
+	}
+	return relPath + ":" + name
+}
+
+/*
+filter restricts the Suite to the query files and test cases selected by f,
+before anything is run. The test cases selected in a query file are then
+kept in s.cases, so that getPlans only returns those.
+
+It returns an error when a path doesn't match any query file, when a test
+case is not found in its plan, or when f.Run isn't a valid regular
+expression.
+*/
+func (s *Suite) filter(config config, f Filter) error {
+	if f.Run != "" {
+		re, err := regexp.Compile(f.Run)
+		if err != nil {
+			return fmt.Errorf("Invalid --run regular expression '%s': %s\n", f.Run, err)
+		}
+		s.run = re
+	}
+
+	var filters []pathFilter
+	for _, p := range f.Paths {
+		filters = append(filters, parsePathFilter(p))
+	}
+	matched := make([]bool, len(filters))
+
+	if len(filters) > 0 {
+		s.cases = make(map[string]map[string]bool)
+	}
+
+	var dirs []Folder
+	for _, folder := range s.Dirs {
+		d := newFolder(folder.Dir)
+
+		for _, name := range folder.Files {
+			relPath := filepath.Join(folder.Dir, name)
+			selected := len(filters) == 0
+			var cases map[string]bool
+
+			for i, pf := range filters {
+				if !pf.match(relPath) {
+					continue
+				}
+				matched[i] = true
+
+				if pf.name == "" {
+					// the whole file is selected
+					cases = nil
+					selected = true
+					continue
+				}
+				if !selected {
+					if cases == nil {
+						cases = make(map[string]bool)
+					}
+					cases[pf.name] = true
+				}
+			}
+			if !selected && cases == nil {
+				continue
+			}
+			if !selected {
+				s.cases[relPath] = cases
+			}
+
+			if cases != nil || s.run != nil {
+				plans, err := s.loadPlans(folder, name, config)
+				if err != nil {
+					// let the test run report the error
+					d.Files = append(d.Files, name)
+					continue
+				}
+				for caseName := range cases {
+					if !hasCase(plans, caseName) {
+						return fmt.Errorf("Test case '%s' not found in plan '%s'\n",
+							caseName, plans[0].Path)
+					}
+				}
+				n := 0
+				for _, p := range plans {
+					if s.selectCases(relPath, p) {
+						n++
+					}
+				}
+				if n == 0 {
+					continue
+				}
+			}
+			d.Files = append(d.Files, name)
+		}
+		if len(d.Files) > 0 {
+			dirs = append(dirs, *d)
+		}
+	}
+
+	for i, pf := range filters {
+		if !matched[i] {
+			return fmt.Errorf("Query file '%s' not found in the test suite\n",
+				pf.pattern)
+		}
+	}
+	s.Dirs = dirs
+	return nil
+}
+
+// versionedFiles returns the set of the Suite query files matching paths,
+// the ones writing version-specific expected files. It returns an error
+// when a path doesn't match any of the Suite query files.
+func (s *Suite) versionedFiles(paths []string) (map[string]bool, error) {
+	files := make(map[string]bool)
+
+	for _, p := range paths {
+		pf := parsePathFilter(p)
+		matched := false
+
+		for _, folder := range s.Dirs {
+			for _, name := range folder.Files {
+				relPath := filepath.Join(folder.Dir, name)
+				if pf.match(relPath) {
+					files[relPath] = true
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("Query file '%s' not found in the updated tests\n",
+				pf.pattern)
+		}
+	}
+	return files, nil
+}
+
+// selectCases removes from the Plan the test cases that are not selected by
+// the Suite filter, and returns false when nothing is left to run.
+//
+// The test cases of a named query are selected by the query name, or by
+// their name prefixed with the query name, as in
+// src/sql/albums.sql:list-albums-by-artist:1.
+func (s *Suite) selectCases(relPath string, p *Plan) bool {
+	cases := s.cases[relPath]
+	if cases == nil && s.run == nil {
+		return true
+	}
+	if cases != nil && p.Query.Name != "" && cases[p.Query.Name] {
+		cases = nil
+	}
+
+	if len(p.Query.Params) == 0 {
+		name := p.Query.Name
+		if cases != nil && !cases[name] {
+			return false
+		}
+		return s.run == nil || s.run.MatchString(testName(relPath, name))
+	}
+
+	var names []string
+	var bindings []map[string]interface{}
+
+	for i, name := range p.Names {
+		if cases != nil && !cases[p.caseName(i)] {
+			continue
+		}
+		if s.run != nil && !s.run.MatchString(testName(relPath, p.caseName(i))) {
+			continue
+		}
+		names = append(names, name)
+		bindings = append(bindings, p.Bindings[i])
+	}
+	p.Names = names
+	p.Bindings = bindings
+	return len(names) > 0
+}
+
+// caseName returns the name of the i-th test case of the Plan, prefixed
+// with the query name for named queries.
+func (p *Plan) caseName(i int) string {
+	if p.Query.Name == "" {
+		return p.Names[i]
+	}
+	return p.Query.Name + ":" + p.Names[i]
+}
+
+// hasCase returns true when name is a test case of one of the plans, or the
+// name of one of their queries.
+func hasCase(plans []*Plan, name string) bool {
+	for _, p := range plans {
+		if p.Query.Name != "" && p.Query.Name == name {
+			return true
+		}
+		for i := range p.Names {
+			if p.caseName(i) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contains returns true when name is found in names.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package regresql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// filterSuite creates a small project with query files and plans in a
+// temporary directory and returns its Suite.
+func filterSuite(t *testing.T) *Suite {
+	t.Helper()
+	root := t.TempDir()
+
+	files := map[string]string{
+		"src/sql/artist.sql":             "select * from artist where name = :name;\n",
+		"src/sql/album-by-artist.sql":    "select * from album where artist = :name;\n",
+		"src/sql/reports/genre-topn.sql": "select * from genre;\n",
+		"regresql/plans/src/sql/artist.yaml": "red-hot:\n  name: Red Hot Chili Peppers\n" +
+			"ac-dc:\n  name: AC/DC\n",
+		"regresql/plans/src/sql/album-by-artist.yaml": "\"1\":\n  name: AC/DC\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return Walk(root)
+}
+
+// selected returns the test names selected in s.
+func selected(t *testing.T, s *Suite) []string {
+	t.Helper()
+	var names []string
+	for _, job := range s.jobs() {
+		plans, err := s.getPlans(job.folder, job.name, config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		relPath := filepath.Join(job.folder.Dir, job.name)
+		for _, p := range plans {
+			if len(p.Query.Params) == 0 {
+				names = append(names, testName(relPath, p.Query.Name))
+			}
+			for i := range p.Names {
+				names = append(names, testName(relPath, p.caseName(i)))
+			}
+		}
+	}
+	return names
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		filter   Filter
+		expected []string
+	}{
+		{Filter{}, []string{
+			"src/sql/album-by-artist.sql:1",
+			"src/sql/artist.sql:red-hot",
+			"src/sql/artist.sql:ac-dc",
+			"src/sql/reports/genre-topn.sql",
+		}},
+		{Filter{Paths: []string{"src/sql/artist.sql"}}, []string{
+			"src/sql/artist.sql:red-hot",
+			"src/sql/artist.sql:ac-dc",
+		}},
+		{Filter{Paths: []string{"src/sql/reports/"}}, []string{
+			"src/sql/reports/genre-topn.sql",
+		}},
+		{Filter{Paths: []string{"src/sql/a*.sql"}}, []string{
+			"src/sql/album-by-artist.sql:1",
+			"src/sql/artist.sql:red-hot",
+			"src/sql/artist.sql:ac-dc",
+		}},
+		{Filter{Paths: []string{"src/sql/artist.sql:ac-dc"}}, []string{
+			"src/sql/artist.sql:ac-dc",
+		}},
+		{Filter{Paths: []string{"src/sql/artist.sql:ac-dc", "src/sql/artist.sql"}}, []string{
+			"src/sql/artist.sql:red-hot",
+			"src/sql/artist.sql:ac-dc",
+		}},
+		{Filter{Run: "red|genre"}, []string{
+			"src/sql/artist.sql:red-hot",
+			"src/sql/reports/genre-topn.sql",
+		}},
+		{Filter{Paths: []string{"src/sql/reports"}, Run: "red"}, nil},
+	}
+
+	for _, test := range tests {
+		s := filterSuite(t)
+		if err := s.filter(config{}, test.filter); err != nil {
+			t.Errorf("%+v: unexpected error: %s", test.filter, err)
+			continue
+		}
+		if names := selected(t, s); !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%+v: expected %q, got %q", test.filter, test.expected, names)
+		}
+	}
+}
+
+func TestFilterNamedQueries(t *testing.T) {
+	tests := []struct {
+		filter   Filter
+		expected []string
+	}{
+		{Filter{Paths: []string{"src/sql/queries.sql"}}, []string{
+			"src/sql/queries.sql:list-artists",
+			"src/sql/queries.sql:list-albums:ac-dc",
+			"src/sql/queries.sql:list-albums:queen",
+		}},
+		{Filter{Paths: []string{"src/sql/queries.sql:list-albums"}}, []string{
+			"src/sql/queries.sql:list-albums:ac-dc",
+			"src/sql/queries.sql:list-albums:queen",
+		}},
+		{Filter{Paths: []string{"src/sql/queries.sql:list-albums:queen"}}, []string{
+			"src/sql/queries.sql:list-albums:queen",
+		}},
+		{Filter{Paths: []string{"src/sql/queries.sql"}, Run: "artists|ac-dc"}, []string{
+			"src/sql/queries.sql:list-artists",
+			"src/sql/queries.sql:list-albums:ac-dc",
+		}},
+	}
+
+	for _, test := range tests {
+		s := filterSuite(t)
+		files := map[string]string{
+			"src/sql/queries.sql": "-- name: list-artists\nselect name from artist;\n" +
+				"-- name: list-albums\nselect title from album where artist = :name;\n",
+			"regresql/plans/src/sql/queries.yaml": "list-albums:\n  ac-dc:\n    name: AC/DC\n" +
+				"  queen:\n    name: Queen\n",
+		}
+		for name, contents := range files {
+			if err := ioutil.WriteFile(filepath.Join(s.Root, name), []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		s = Walk(s.Root)
+
+		if err := s.filter(config{}, test.filter); err != nil {
+			t.Errorf("%+v: unexpected error: %s", test.filter, err)
+			continue
+		}
+		if names := selected(t, s); !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%+v: expected %q, got %q", test.filter, test.expected, names)
+		}
+	}
+}
+
+func TestVersionedFiles(t *testing.T) {
+	s := filterSuite(t)
+
+	files, err := s.versionedFiles([]string{"src/sql/reports", "src/sql/artist.sql"})
+	expected := map[string]bool{"src/sql/artist.sql": true, "src/sql/reports/genre-topn.sql": true}
+	if err != nil || !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected %v, got %v (%v)", expected, files, err)
+	}
+
+	if files, err := s.versionedFiles([]string{"."}); err != nil || len(files) != 3 {
+		t.Errorf("Expected every query file to be versioned, got %v (%v)", files, err)
+	}
+	if files, err := s.versionedFiles(nil); err != nil || len(files) != 0 {
+		t.Errorf("Expected no versioned query file, got %v (%v)", files, err)
+	}
+
+	if err := s.filter(config{}, Filter{Paths: []string{"src/sql/artist.sql"}}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, err := s.versionedFiles([]string{"src/sql/reports"}); err == nil {
+		t.Error("Expected an error for a versioned path not selected, got nil")
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, filter := range []Filter{
+		{Paths: []string{"src/sql/missing.sql"}},
+		{Paths: []string{"src/sql/artist.sql:missing"}},
+		{Run: "("},
+	} {
+		s := filterSuite(t)
+		if err := s.filter(config{}, filter); err == nil {
+			t.Errorf("%+v: expected an error", filter)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package regresql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Names of the per-directory setup and teardown scripts. Those files are
+// not query files, and are skipped when walking the code repository.
+const (
+	setupScript    = "setup.sql"
+	teardownScript = "teardown.sql"
+)
+
+// isHookScript returns true when path is a setup or teardown script.
+func isHookScript(path string) bool {
+	name := filepath.Base(path)
+	return name == setupScript || name == teardownScript
+}
+
+// ErrBailOut is returned when a setup or teardown script fails, in which
+// case the tests can't be trusted and the run is aborted.
+type ErrBailOut struct{ Reason string }
+
+func (e *ErrBailOut) Error() string {
+	return fmt.Sprintf("Bail out! %s\n", e.Reason)
+}
+
+// runScript runs the SQL script found in filename against db, canceling it
+// when ctx is done.
+func runScript(ctx context.Context, db *sql.DB, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return &ErrBailOut{fmt.Sprintf("Failed to read script '%s': %s", filename, err)}
+	}
+	if _, err := db.ExecContext(ctx, string(data)); err != nil {
+		return &ErrBailOut{fmt.Sprintf("Failed to run script '%s': %s", filename, err)}
+	}
+	return nil
+}
+
+// A batch is a list of consecutive folders of a Suite to run with the same
+// setup and teardown scripts, which are empty when the folders have none.
+type batch struct {
+	dirs     []Folder
+	setup    string
+	teardown string
+}
+
+// batches returns the folders of the Suite in batches: a folder with its own
+// setup.sql or teardown.sql script is a batch of its own, and consecutive
+// folders without scripts are run together.
+func (s *Suite) batches() []batch {
+	var batches []batch
+
+	script := func(folder Folder, name string) string {
+		path := filepath.Join(s.Root, folder.Dir, name)
+		if _, err := os.Stat(path); err != nil {
+			return ""
+		}
+		return path
+	}
+
+	for _, folder := range s.Dirs {
+		b := batch{
+			dirs:     []Folder{folder},
+			setup:    script(folder, setupScript),
+			teardown: script(folder, teardownScript),
+		}
+		n := len(batches)
+		hooked := b.setup != "" || b.teardown != ""
+
+		if !hooked && n > 0 && batches[n-1].setup == "" && batches[n-1].teardown == "" {
+			batches[n-1].dirs = append(batches[n-1].dirs, folder)
+			continue
+		}
+		batches = append(batches, b)
+	}
+	return batches
+}
+
+/*
+runSuite runs the query files of the Suite with runJobs, between the setup
+and teardown scripts of the config, which are run once, and of each
+directory, which are run around the queries of that directory.
+
+Teardown scripts are run even when the queries failed to run, as long as
+their setup succeeded. Scripts failures are returned as *ErrBailOut.
+
+When ctx is done, the queries in flight are canceled, no other query file
+is run, and the teardown scripts are still run before returning an
+*ErrBailOut.
+*/
+func (s *Suite) runSuite(ctx context.Context, db *sql.DB, config config, n int,
+	run func(job queryJob) ([]*Plan, error),
+	report func(job queryJob, plans []*Plan) error) error {
+
+	for _, script := range config.Setup {
+		if err := runScript(ctx, db, filepath.Join(s.Root, script)); err != nil {
+			return interrupted(ctx, err)
+		}
+	}
+
+	err := s.runBatches(ctx, db, n, run, report)
+
+	// teardown scripts run even after an interruption
+	for _, script := range config.Teardown {
+		if terr := runScript(context.Background(), db, filepath.Join(s.Root, script)); terr != nil && err == nil {
+			err = terr
+		}
+	}
+	return interrupted(ctx, err)
+}
+
+// interrupted returns an *ErrBailOut when ctx is done, and err otherwise.
+func interrupted(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return &ErrBailOut{"Interrupted"}
+	}
+	return err
+}
+
+// runBatches runs the Suite batches in order, see runSuite.
+func (s *Suite) runBatches(ctx context.Context, db *sql.DB, n int,
+	run func(job queryJob) ([]*Plan, error),
+	report func(job queryJob, plans []*Plan) error) error {
+
+	for _, b := range s.batches() {
+		if b.setup != "" {
+			if err := runScript(ctx, db, b.setup); err != nil {
+				return err
+			}
+		}
+
+		sub := *s
+		sub.Dirs = b.dirs
+		err := sub.runJobs(n, run, report)
+
+		if b.teardown != "" {
+			if terr := runScript(context.Background(), db, b.teardown); terr != nil && err == nil {
+				err = terr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package regresql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSuiteBatches(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"a/q.sql",
+		"b/q.sql",
+		"c/setup.sql", "c/q.sql",
+		"d/q.sql",
+		"e/q.sql", "e/teardown.sql",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("select 1;\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := Walk(root)
+	for _, folder := range s.Dirs {
+		if !reflect.DeepEqual(folder.Files, []string{"q.sql"}) {
+			t.Errorf("Expected only q.sql in %s, got %v", folder.Dir, folder.Files)
+		}
+	}
+
+	var got [][]string
+	for _, b := range s.batches() {
+		var dirs []string
+		for _, folder := range b.dirs {
+			dirs = append(dirs, folder.Dir)
+		}
+		if b.setup != "" {
+			dirs = append(dirs, filepath.Base(b.setup))
+		}
+		if b.teardown != "" {
+			dirs = append(dirs, filepath.Base(b.teardown))
+		}
+		got = append(got, dirs)
+	}
+	expected := [][]string{{"a", "b"}, {"c", "setup.sql"}, {"d"}, {"e", "teardown.sql"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected batches %v, got %v", expected, got)
+	}
+}
+
+func TestReadConfigScripts(t *testing.T) {
+	s := writeConfig(t, "pguri: postgres:///db\nsetup: fixtures/load.sql\nteardown:\n  - fixtures/a.sql\n  - fixtures/b.sql\n")
+
+	config, err := s.readConfig()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !reflect.DeepEqual(config.Setup, []string{"fixtures/load.sql"}) {
+		t.Errorf("Expected one setup script, got %v", config.Setup)
+	}
+	if len(config.Teardown) != 2 {
+		t.Errorf("Expected two teardown scripts, got %v", config.Teardown)
+	}
+}
@@ -0,0 +1,101 @@
+package regresql
+
+import (
+	"sync"
+)
+
+// A queryJob is a query file of a Suite, found in folder.
+type queryJob struct {
+	folder Folder
+	name   string
+}
+
+// A jobResult is the outcome of running a queryJob, done is closed once plans
+// and err are set.
+type jobResult struct {
+	plans []*Plan
+	err   error
+	done  chan struct{}
+}
+
+// jobs returns the list of query files in the Suite, in order.
+func (s *Suite) jobs() []queryJob {
+	var jobs []queryJob
+	for _, folder := range s.Dirs {
+		for _, name := range folder.Files {
+			jobs = append(jobs, queryJob{folder, name})
+		}
+	}
+	return jobs
+}
+
+/*
+runJobs runs the query files of the Suite concurrently, using at most n
+goroutines, and calls report for each of them in the Suite order, as soon as
+the result for that file is available. The report function is always called
+from the calling goroutine, so that it can write output and aggregate
+counters without further synchronisation.
+
+When run or report returns an error, no new job is started and runJobs
+returns that error once the jobs in flight are done.
+*/
+func (s *Suite) runJobs(n int,
+	run func(job queryJob) ([]*Plan, error),
+	report func(job queryJob, plans []*Plan) error) error {
+	jobs := s.jobs()
+
+	if n < 1 {
+		n = 1
+	}
+	if n > len(jobs) {
+		n = len(jobs)
+	}
+
+	results := make([]jobResult, len(jobs))
+	for i := range results {
+		results[i].done = make(chan struct{})
+	}
+
+	queue := make(chan int)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i].plans, results[i].err = run(jobs[i])
+				close(results[i].done)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(queue)
+		for i := range jobs {
+			select {
+			case queue <- i:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	var err error
+	for i := range jobs {
+		<-results[i].done
+
+		if err = results[i].err; err == nil {
+			err = report(jobs[i], results[i].plans)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	close(quit)
+	wg.Wait()
+
+	return err
+}
@@ -0,0 +1,77 @@
+package regresql
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// jobsSuite returns a Suite with folders folders of files query files each.
+func jobsSuite(folders, files int) *Suite {
+	s := newSuite("root")
+	for d := 0; d < folders; d++ {
+		for f := 0; f < files; f++ {
+			s.appendPath(filepath.Join("root", fmt.Sprintf("d%d", d), fmt.Sprintf("q%d.sql", f)))
+		}
+	}
+	return s
+}
+
+func TestRunJobsReportsInOrder(t *testing.T) {
+	s := jobsSuite(3, 5)
+
+	run := func(job queryJob) ([]*Plan, error) {
+		// make the first files of each folder the slowest to finish
+		var n int
+		fmt.Sscanf(job.name, "q%d.sql", &n)
+		time.Sleep(time.Duration(5-n) * time.Millisecond)
+		return []*Plan{{Path: filepath.Join(job.folder.Dir, job.name)}}, nil
+	}
+
+	var reported []string
+	report := func(job queryJob, plans []*Plan) error {
+		reported = append(reported, plans[0].Path)
+		return nil
+	}
+
+	if err := s.runJobs(4, run, report); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	jobs := s.jobs()
+	if len(reported) != len(jobs) {
+		t.Fatalf("Expected %d reports, got %d", len(jobs), len(reported))
+	}
+	for i, job := range jobs {
+		if expected := filepath.Join(job.folder.Dir, job.name); reported[i] != expected {
+			t.Errorf("Expected report %d to be %q, got %q", i, expected, reported[i])
+		}
+	}
+}
+
+func TestRunJobsStopsOnError(t *testing.T) {
+	s := jobsSuite(1, 10)
+	failure := errors.New("failure")
+
+	run := func(job queryJob) ([]*Plan, error) {
+		if job.name == "q3.sql" {
+			return nil, failure
+		}
+		return []*Plan{{}}, nil
+	}
+
+	reports := 0
+	report := func(job queryJob, plans []*Plan) error {
+		reports++
+		return nil
+	}
+
+	if err := s.runJobs(2, run, report); err != failure {
+		t.Fatalf("Expected the run error, got %v", err)
+	}
+	if reports != 3 {
+		t.Errorf("Expected 3 reports before the failure, got %d", reports)
+	}
+}
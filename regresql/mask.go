@@ -0,0 +1,162 @@
+package regresql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+A Mask is a rule that replaces volatile values, such as now() or generated
+identifiers, with a stable placeholder in the result files. A Mask selects
+the values of a Column, of a PostgreSQL Type, or matching a Pattern regular
+expression; when several of them are set, the values must match them all.
+
+The whole value is replaced, unless a Pattern is given, in which case only
+the matching parts of the value are replaced. The placeholder is Replace,
+or defaults to a name derived from the column type, such as <timestamp> or
+<uuid>. NULL values are never masked.
+
+Masks are set in regress.yaml:
+
+	mask:
+	  - type: timestamptz
+	  - column: id
+	    replace: <id>
+
+or with a query file header, or a plan file option:
+
+	-- regresql: mask pattern='[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f-]{22}' replace=<uuid>
+*/
+type Mask struct {
+	Column  string `mapstructure:"column"`
+	Type    string `mapstructure:"type"`
+	Pattern string `mapstructure:"pattern"`
+	Replace string `mapstructure:"replace"`
+}
+
+// parseMask returns the Mask from directive arguments given as key=value
+// pairs.
+func parseMask(args []string) (Mask, error) {
+	var m Mask
+
+	for _, arg := range args {
+		kv, err := splitKeyValue("mask", arg)
+		if err != nil {
+			return m, err
+		}
+		switch kv[0] {
+		case "column":
+			m.Column = kv[1]
+		case "type":
+			m.Type = kv[1]
+		case "pattern":
+			m.Pattern = kv[1]
+		case "replace":
+			m.Replace = kv[1]
+		default:
+			return m, fmt.Errorf("unknown mask setting %q", kv[0])
+		}
+	}
+	if m.Column == "" && m.Type == "" && m.Pattern == "" {
+		return m, fmt.Errorf("mask expects one of column, type or pattern, got %v", args)
+	}
+	if _, err := regexp.Compile(m.Pattern); err != nil {
+		return m, fmt.Errorf("invalid mask pattern %q: %s", m.Pattern, err)
+	}
+	return m, nil
+}
+
+// placeholders are the default mask placeholders by PostgreSQL type.
+var placeholders = map[string]string{
+	"timestamp":   "<timestamp>",
+	"timestamptz": "<timestamp>",
+	"date":        "<date>",
+	"time":        "<time>",
+	"timetz":      "<time>",
+	"interval":    "<interval>",
+	"uuid":        "<uuid>",
+	"int2":        "<integer>",
+	"int4":        "<integer>",
+	"int8":        "<integer>",
+}
+
+// placeholder returns the value that replaces masked values of the given
+// column type.
+func (m Mask) placeholder(typename string) string {
+	if m.Replace != "" {
+		return m.Replace
+	}
+	if p, ok := placeholders[typename]; ok {
+		return p
+	}
+	if typename != "" {
+		return "<" + typename + ">"
+	}
+	return "<masked>"
+}
+
+// typeAliases maps the SQL standard type names that can be used in a Mask
+// to the PostgreSQL internal type names found in result sets.
+var typeAliases = map[string]string{
+	"timestamp with time zone":    "timestamptz",
+	"timestamp without time zone": "timestamp",
+	"time with time zone":         "timetz",
+	"time without time zone":      "time",
+	"smallint":                    "int2",
+	"integer":                     "int4",
+	"int":                         "int4",
+	"bigint":                      "int8",
+}
+
+// matchType returns true when the Mask applies to columns of typename.
+func (m Mask) matchType(typename string) bool {
+	if m.Type == "" {
+		return true
+	}
+	t := strings.ToLower(m.Type)
+	if alias, ok := typeAliases[t]; ok {
+		t = alias
+	}
+	return t == typename
+}
+
+/*
+Mask replaces the values selected by the masks with their placeholder, in
+place. It is applied before writing result files, so that both the expected
+and actual result files contain the placeholders.
+*/
+func (r *ResultSet) Mask(masks []Mask) error {
+	for _, m := range masks {
+		re, err := regexp.Compile(m.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid mask pattern %q: %s", m.Pattern, err)
+		}
+
+		for i, name := range r.Cols {
+			typename := ""
+			if i < len(r.Types) {
+				typename = r.Types[i]
+			}
+			if (m.Column != "" && m.Column != name) || !m.matchType(typename) {
+				continue
+			}
+			placeholder := m.placeholder(typename)
+
+			for _, row := range r.Rows {
+				if row[i] == nil {
+					continue
+				}
+				if m.Pattern == "" {
+					row[i] = placeholder
+					continue
+				}
+				s := valueToString(row[i])
+				if re.MatchString(s) {
+					row[i] = re.ReplaceAllLiteralString(s, placeholder)
+				}
+			}
+		}
+	}
+	return nil
+}
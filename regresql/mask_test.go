@@ -0,0 +1,78 @@
+package regresql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResultSetMask(t *testing.T) {
+	rs := ResultSet{
+		Cols:  []string{"id", "created", "name", "note"},
+		Types: []string{"int8", "timestamptz", "text", "text"},
+		Rows: [][]interface{}{
+			{int64(42), time.Now(), "AC/DC", "ref 1b4e28ba-2fa1-11d2-883f-0016d3cca427"},
+			{int64(43), nil, "Accept", nil},
+		},
+	}
+	masks := []Mask{
+		{Type: "timestamp with time zone"},
+		{Column: "id", Replace: "<id>"},
+		{Pattern: "[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f-]{22}", Replace: "<uuid>"},
+	}
+	if err := rs.Mask(masks); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expected := " id  |   created   |  name  |    note   \n" +
+		"-----+-------------+--------+-----------\n" +
+		"<id> | <timestamp> | AC/DC  | ref <uuid>\n" +
+		"<id> | <nil>       | Accept | <nil>\n"
+	if out := rs.PrettyPrint(); out != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestMaskDirective(t *testing.T) {
+	q := mustParseQueryString(t, "src/sql/q.sql",
+		"-- regresql: mask column=created\n-- regresql: mask type=uuid replace=<id>\nSELECT 1;\n")
+	opts, err := q.resolveOptions(Options{Mask: []Mask{{Type: "date"}}})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := []Mask{{Type: "date"}, {Column: "created"}, {Type: "uuid", Replace: "<id>"}}
+	if len(opts.Mask) != len(expected) {
+		t.Fatalf("Expected masks %+v, got %+v", expected, opts.Mask)
+	}
+	for i := range expected {
+		if opts.Mask[i] != expected[i] {
+			t.Errorf("Expected masks %+v, got %+v", expected, opts.Mask)
+		}
+	}
+
+	for _, header := range []string{
+		"-- regresql: mask\n",
+		"-- regresql: mask replace=x\n",
+		"-- regresql: mask pattern=(\n",
+	} {
+		q := mustParseQueryString(t, "src/sql/q.sql", header+"SELECT 1;\n")
+		if _, err := q.resolveOptions(Options{}); err == nil {
+			t.Errorf("Expected an error for %q, got nil", header)
+		}
+	}
+}
+
+func TestMaskConfig(t *testing.T) {
+	s := writeConfig(t, "pguri: postgres:///db\nmask:\n  - type: timestamptz\n  - column: id\n    replace: <id>\n")
+	config, err := s.readConfig()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(config.Mask) != 2 || config.Mask[0].Type != "timestamptz" || config.Mask[1].Replace != "<id>" {
+		t.Errorf("Expected two masks from regress.yaml, got %+v", config.Mask)
+	}
+
+	s = writeConfig(t, "pguri: postgres:///db\nmask:\n  - pattern: \"(\"\n")
+	if _, err := s.readConfig(); err == nil {
+		t.Error("Expected an error for an invalid mask pattern, got nil")
+	}
+}
@@ -0,0 +1,236 @@
+package regresql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// directiveLineRE matches a `-- regresql: name [args...]` comment line in a
+// query file, capturing the rest-of-line directive text (group 1).
+var directiveLineRE = regexp.MustCompile(`(?m)^[ \t]*--[ \t]*regresql:[ \t]*([^\r\n]*)$`)
+
+/*
+Options control how the queries of a Suite are run and compared. Default
+values are read from the regress.yaml configuration file, and a query file
+may override them with header comments such as:
+
+	-- regresql: rollback
+	select ...
+*/
+type Options struct {
+	Rollback  bool              // run each binding in a transaction that is rolled back
+	Unordered bool              // compare result sets as multisets of rows
+	Format    string            // result files format, one of ResultFormats
+	Tolerance []Tolerance       // numeric comparison rules
+	Mask      []Mask            // volatile values to replace in result files
+	Timing    Timing            // performance regressions thresholds
+	Explain   bool              // also compare the query plans
+	Settings  map[string]string // session settings (GUCs) to set first
+	Role      string            // role to SET ROLE to first
+	Timeout   time.Duration     // cancel queries running longer, when > 0
+
+	// the error every binding must fail with, only set with directives
+	ExpectError *ExpectedError `mapstructure:"-"`
+}
+
+/*
+A Directive is a `-- regresql:` comment found in a query file. The first
+word is the directive Name, the following words are its Args, tokenized
+with the same quoting rules as psql \bind, and where a quoted value may
+follow a key= argument:
+
+	-- regresql: rollback false
+	-> Name = "rollback", Args = ["false"]
+
+	-- regresql: set DateStyle='ISO, YMD'
+	-> Name = "set", Args = ["DateStyle=ISO, YMD"]
+*/
+type Directive struct {
+	Name string
+	Args []string
+}
+
+// extractDirectives scans text for `-- regresql:` comment lines and returns
+// the directives found, in order. The lines are left in the SQL text, as
+// they are comments.
+func extractDirectives(text string) []Directive {
+	var directives []Directive
+
+	for _, m := range directiveLineRE.FindAllStringSubmatch(text, -1) {
+		tokens := joinKeyValues(parseBindTokens(strings.TrimRight(m[1], " \t")))
+		if len(tokens) == 0 {
+			continue
+		}
+		directives = append(directives, Directive{tokens[0], tokens[1:]})
+	}
+	return directives
+}
+
+// joinKeyValues joins the tokens ending with an equal sign with the token
+// that follows them, which parseBindTokens splits when it's quoted.
+func joinKeyValues(tokens []string) []string {
+	var joined []string
+
+	for i := 0; i < len(tokens); i++ {
+		if strings.HasSuffix(tokens[i], "=") && i+1 < len(tokens) {
+			joined = append(joined, tokens[i]+tokens[i+1])
+			i++
+			continue
+		}
+		joined = append(joined, tokens[i])
+	}
+	return joined
+}
+
+// apply modifies o according to the directive d.
+func (o *Options) apply(d Directive) error {
+	switch d.Name {
+	case "rollback":
+		v, err := d.boolArg()
+		if err != nil {
+			return err
+		}
+		o.Rollback = v
+
+	case "unordered":
+		v, err := d.boolArg()
+		if err != nil {
+			return err
+		}
+		o.Unordered = v
+
+	case "explain":
+		v, err := d.boolArg()
+		if err != nil {
+			return err
+		}
+		o.Explain = v
+
+	case "set":
+		if len(d.Args) == 1 && strings.ToLower(d.Args[0]) == "off" {
+			o.Settings = nil
+			break
+		}
+		settings, err := parseSettings(o.Settings, d.Args)
+		if err != nil {
+			return err
+		}
+		o.Settings = settings
+
+	case "role":
+		if len(d.Args) != 1 {
+			return fmt.Errorf("directive %q expects one argument, got %v",
+				d.Name, d.Args)
+		}
+		o.Role = d.Args[0]
+		if strings.ToLower(o.Role) == "off" {
+			o.Role = ""
+		}
+
+	case "timeout":
+		d, err := parseTimeout(d.Args)
+		if err != nil {
+			return err
+		}
+		o.Timeout = d
+
+	case expectErrorKey:
+		if len(d.Args) == 1 && strings.ToLower(d.Args[0]) == "off" {
+			o.ExpectError = nil
+			break
+		}
+		e, err := parseExpectedError(d.Args)
+		if err != nil {
+			return err
+		}
+		o.ExpectError = e
+
+	case "format":
+		if len(d.Args) != 1 {
+			return fmt.Errorf("directive %q expects one argument, got %v",
+				d.Name, d.Args)
+		}
+		if err := checkResultFormat(d.Args[0]); err != nil {
+			return err
+		}
+		o.Format = d.Args[0]
+
+	case "tolerance":
+		if len(d.Args) == 1 && strings.ToLower(d.Args[0]) == "off" {
+			o.Tolerance = nil
+			break
+		}
+		t, err := parseTolerance(d.Args)
+		if err != nil {
+			return err
+		}
+		// don't share the backing array of the defaults
+		o.Tolerance = append(o.Tolerance[:len(o.Tolerance):len(o.Tolerance)], t)
+
+	case "mask":
+		if len(d.Args) == 1 && strings.ToLower(d.Args[0]) == "off" {
+			o.Mask = nil
+			break
+		}
+		m, err := parseMask(d.Args)
+		if err != nil {
+			return err
+		}
+		o.Mask = append(o.Mask[:len(o.Mask):len(o.Mask)], m)
+
+	case "timing":
+		if len(d.Args) == 1 && strings.ToLower(d.Args[0]) == "off" {
+			o.Timing = Timing{}
+			break
+		}
+		t, err := parseTiming(o.Timing, d.Args)
+		if err != nil {
+			return err
+		}
+		o.Timing = t
+
+	default:
+		return fmt.Errorf("unknown regresql directive %q", d.Name)
+	}
+	return nil
+}
+
+// boolArg returns the boolean value of a directive: a bare directive means
+// true, otherwise its only argument is parsed as a boolean, accepting the
+// same on/off spellings as PostgreSQL.
+func (d Directive) boolArg() (bool, error) {
+	switch len(d.Args) {
+	case 0:
+		return true, nil
+	case 1:
+		switch strings.ToLower(d.Args[0]) {
+		case "on", "yes":
+			return true, nil
+		case "off", "no":
+			return false, nil
+		}
+		v, err := strconv.ParseBool(d.Args[0])
+		if err != nil {
+			return false, fmt.Errorf("directive %q expects a boolean, got %q",
+				d.Name, d.Args[0])
+		}
+		return v, nil
+	}
+	return false, fmt.Errorf("directive %q expects at most one argument, got %v",
+		d.Name, d.Args)
+}
+
+// resolveOptions returns the options to use for q, starting from the
+// defaults and applying the query file directives in order.
+func (q *Query) resolveOptions(defaults Options) (Options, error) {
+	opts := defaults
+	for _, d := range q.Directives {
+		if err := opts.apply(d); err != nil {
+			return opts, fmt.Errorf("%s: %s", q.Path, err)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,290 @@
+package regresql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+A TypedParam is a plan parameter value given with an explicit PostgreSQL
+type, as in the following plan:
+
+	"1":
+	  ids:
+	    value: [1, 2, 3]
+	    type: int8[]
+
+The Type is used to convert the Value to the matching Go driver value.
+*/
+type TypedParam struct {
+	Value interface{} `yaml:"value" json:"value"`
+	Type  string      `yaml:"type" json:"type"`
+}
+
+/*
+planValue normalizes a parameter value read from a plan YAML file:
+
+  - null is kept as nil, and binds SQL NULL,
+  - scalars are kept as their text representation, as before,
+  - sequences are kept as []interface{} of normalized values, and bind
+    PostgreSQL arrays,
+  - a mapping with exactly the keys value and type is a TypedParam,
+  - any other mapping is kept as its JSON text, and binds a json or jsonb
+    document.
+*/
+func planValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+
+	case []interface{}:
+		seq := make([]interface{}, len(v))
+		for i, e := range v {
+			val, err := planValue(e)
+			if err != nil {
+				return nil, err
+			}
+			seq[i] = val
+		}
+		return seq, nil
+
+	case yaml.MapSlice:
+		if tp, ok := typedParam(v); ok {
+			var val interface{}
+			var err error
+
+			if _, isString := tp.Value.(string); isJSONType(tp.Type) && !isString {
+				// keep YAML scalars typing in the JSON document
+				var data []byte
+				data, err = marshalJSON(tp.Value)
+				val = string(data)
+			} else {
+				val, err = planValue(tp.Value)
+			}
+			if err != nil {
+				return nil, err
+			}
+			tp.Value = val
+			return tp, nil
+		}
+		data, err := marshalJSON(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+	return fmt.Sprintf("%v", v), nil
+}
+
+// typedParam returns the TypedParam for ms when it only contains the value
+// and type keys.
+func typedParam(ms yaml.MapSlice) (TypedParam, bool) {
+	var tp TypedParam
+	if len(ms) != 2 {
+		return tp, false
+	}
+	found := 0
+	for _, item := range ms {
+		switch fmt.Sprintf("%v", item.Key) {
+		case "value":
+			tp.Value = item.Value
+			found++
+		case "type":
+			s, ok := item.Value.(string)
+			if !ok {
+				return tp, false
+			}
+			tp.Type = s
+			found++
+		}
+	}
+	return tp, found == 2
+}
+
+// marshalJSON returns the JSON encoding of a value decoded from YAML,
+// keeping the keys of mappings in the YAML order.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		b.WriteByte('{')
+		for i, item := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, err := json.Marshal(fmt.Sprintf("%v", item.Key))
+			if err != nil {
+				return nil, err
+			}
+			val, err := marshalJSON(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			b.Write(key)
+			b.WriteByte(':')
+			b.Write(val)
+		}
+		b.WriteByte('}')
+
+	case []interface{}:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			val, err := marshalJSON(e)
+			if err != nil {
+				return nil, err
+			}
+			b.Write(val)
+		}
+		b.WriteByte(']')
+
+	default:
+		return json.Marshal(v)
+	}
+	return b.Bytes(), nil
+}
+
+// paramValue returns the database/sql driver value to bind for v, a value
+// normalized with planValue.
+func paramValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+
+	case []interface{}:
+		a, err := sliceOf(v, "")
+		if err != nil {
+			return nil, err
+		}
+		return pq.Array(a), nil
+
+	case TypedParam:
+		return typedValue(v.Value, v.Type)
+	}
+	return v, nil
+}
+
+// typedValue converts v to the Go driver value that matches the PostgreSQL
+// type typename. Array types (int8[], text[], …) expect a sequence, json
+// and jsonb expect either a JSON text or a YAML structure, and unknown types
+// are bound as text for PostgreSQL to convert.
+func typedValue(v interface{}, typename string) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	t := strings.ToLower(strings.TrimSpace(typename))
+
+	if strings.HasSuffix(t, "[]") {
+		seq, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("type %s expects a sequence, got %v", typename, v)
+		}
+		a, err := sliceOf(seq, strings.TrimSuffix(t, "[]"))
+		if err != nil {
+			return nil, err
+		}
+		return pq.Array(a), nil
+	}
+
+	if isJSONType(t) {
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		data, err := marshalJSON(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("type %s expects a scalar value, got %v", typename, v)
+	}
+
+	switch t {
+	case "int2", "int4", "int8", "smallint", "int", "integer", "bigint":
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q", typename, s)
+		}
+		return i, nil
+
+	case "float4", "float8", "real", "double precision":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q", typename, s)
+		}
+		return f, nil
+
+	case "bool", "boolean":
+		b, err := Directive{typename, []string{s}}.boolArg()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %q", typename, s)
+		}
+		return b, nil
+	}
+	return s, nil
+}
+
+// isJSONType returns true when typename is json or jsonb
+func isJSONType(typename string) bool {
+	t := strings.ToLower(strings.TrimSpace(typename))
+	return t == "json" || t == "jsonb"
+}
+
+// interfaceType is the reflect.Type of interface{}
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// sliceOf returns the sequence seq as a Go slice with one level of nesting
+// per array dimension, e.g. [][]interface{} for a sequence of sequences, so
+// that pq.GenericArray encodes it as a multi-dimensional PostgreSQL array.
+// Elements are converted with typedValue when elemType is not empty.
+func sliceOf(seq []interface{}, elemType string) (interface{}, error) {
+	values := make([]reflect.Value, len(seq))
+	t := interfaceType
+
+	for i, e := range seq {
+		var v reflect.Value
+
+		if sub, ok := e.([]interface{}); ok {
+			a, err := sliceOf(sub, elemType)
+			if err != nil {
+				return nil, err
+			}
+			v = reflect.ValueOf(a)
+		} else {
+			val := e
+			if elemType != "" {
+				var err error
+				if val, err = typedValue(e, elemType); err != nil {
+					return nil, err
+				}
+			}
+			v = reflect.ValueOf(&val).Elem()
+		}
+
+		if i == 0 {
+			t = v.Type()
+		} else if v.Type() != t {
+			return nil, fmt.Errorf("array %v has mixed dimensions", seq)
+		}
+		values[i] = v
+	}
+
+	a := reflect.MakeSlice(reflect.SliceOf(t), len(seq), len(seq))
+	for i, v := range values {
+		a.Index(i).Set(v)
+	}
+	return a.Interface(), nil
+}
@@ -0,0 +1,97 @@
+package regresql
+
+import (
+	"database/sql/driver"
+	"testing"
+)
+
+// prepareFromPlan parses plan as the single test case of a plan file for the
+// query text, and returns the driver values of the prepared query arguments.
+func prepareFromPlan(t *testing.T, text string, plan string) []driver.Value {
+	t.Helper()
+	q := mustParseQueryString(t, "no/path", text)
+
+	_, bindings, err := parsePlan([]byte(plan))
+	if err != nil {
+		t.Fatal("Unexpected error parsing plan:", err)
+	}
+	_, params, err := q.Prepare(bindings[0])
+	if err != nil {
+		t.Fatal("Unexpected error from Prepare:", err)
+	}
+
+	values := make([]driver.Value, len(params))
+	for i, p := range params {
+		if valuer, ok := p.(driver.Valuer); ok {
+			if values[i], err = valuer.Value(); err != nil {
+				t.Fatal("Unexpected error from Value:", err)
+			}
+		} else {
+			values[i] = p
+		}
+	}
+	return values
+}
+
+func TestPlanNullParam(t *testing.T) {
+	values := prepareFromPlan(t, "SELECT :a, :b;\n", "\"1\":\n  a: ~\n  b: null\n")
+
+	if values[0] != nil || values[1] != nil {
+		t.Errorf("Expected NULL values, got %v", values)
+	}
+}
+
+func TestPlanArrayParam(t *testing.T) {
+	values := prepareFromPlan(t, "SELECT $1, $2;\n", "\"1\":\n  - [1, 2, ~]\n  - [[a, b], [c, 'd e']]\n")
+
+	if values[0] != "{\"1\",\"2\",NULL}" {
+		t.Errorf("Expected {\"1\",\"2\",NULL}, got %v", values[0])
+	}
+	if values[1] != "{{\"a\",\"b\"},{\"c\",\"d e\"}}" {
+		t.Errorf("Expected a two-dimensional array, got %v", values[1])
+	}
+}
+
+func TestPlanJSONParam(t *testing.T) {
+	values := prepareFromPlan(t, "SELECT :doc::jsonb;\n",
+		"\"1\":\n  doc:\n    name: AC/DC\n    tags: [rock, \"hard rock\"]\n    albums: 2\n")
+
+	expected := `{"name":"AC/DC","tags":["rock","hard rock"],"albums":2}`
+	if values[0] != expected {
+		t.Errorf("Expected %s, got %v", expected, values[0])
+	}
+}
+
+func TestPlanTypedParam(t *testing.T) {
+	values := prepareFromPlan(t, "SELECT :ids, :num, :ok, :doc;\n", `"1":
+  ids: {value: [1, 2, 3], type: "int8[]"}
+  num: {value: "42", type: int4}
+  ok: {value: on, type: boolean}
+  doc: {value: [1, {a: b}], type: json}
+`)
+
+	if values[0] != "{1,2,3}" {
+		t.Errorf("Expected {1,2,3}, got %v", values[0])
+	}
+	if values[1] != int64(42) {
+		t.Errorf("Expected int64(42), got %#v", values[1])
+	}
+	if values[2] != true {
+		t.Errorf("Expected true, got %#v", values[2])
+	}
+	if values[3] != `[1,{"a":"b"}]` {
+		t.Errorf("Expected [1,{\"a\":\"b\"}], got %v", values[3])
+	}
+}
+
+func TestPlanTypedParamInvalid(t *testing.T) {
+	q := mustParseQueryString(t, "no/path", "SELECT :num;\n")
+
+	_, bindings, err := parsePlan([]byte("\"1\":\n  num: {value: abc, type: int8}\n"))
+	if err != nil {
+		t.Fatal("Unexpected error parsing plan:", err)
+	}
+	if _, _, err := q.Prepare(bindings[0]); err == nil {
+		t.Error("Expected error for invalid int8 value, got nil")
+	}
+}
@@ -0,0 +1,265 @@
+package regresql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// envVarRE matches the ${NAME} environment variable references of a
+// connection string. The $NAME form is not expanded, as passwords may
+// contain dollar signs.
+var envVarRE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the ${NAME} references in pguri with the value of the
+// NAME environment variable, which must be set.
+func expandEnv(pguri string) (string, error) {
+	var err error
+
+	expanded := envVarRE.ReplaceAllStringFunc(pguri, func(ref string) string {
+		name := envVarRE.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("Failed to expand connection string: environment variable %s is not set\n", name)
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// ServiceEnvVars are the environment variables used to find connection
+// services, as with libpq. lib/pq refuses to connect when they are set, so
+// the regresql command reads them with LookupServiceEnv before it unsets
+// them, and the connection strings are resolved with the values it read.
+var ServiceEnvVars = []string{"PGSERVICE", "PGSERVICEFILE", "PGSYSCONFDIR"}
+
+// A ServiceEnv holds the values of the ServiceEnvVars.
+type ServiceEnv struct {
+	Service    string // PGSERVICE, the default service name
+	File       string // PGSERVICEFILE, the user service file
+	SysconfDir string // PGSYSCONFDIR, where the system service file is
+}
+
+// LookupServiceEnv returns the values of the ServiceEnvVars, leaving the
+// environment alone.
+func LookupServiceEnv() ServiceEnv {
+	return ServiceEnv{
+		Service:    os.Getenv("PGSERVICE"),
+		File:       os.Getenv("PGSERVICEFILE"),
+		SysconfDir: os.Getenv("PGSYSCONFDIR"),
+	}
+}
+
+/*
+resolvePgUri returns the connection string to use for pguri, which may be
+a postgres:// URI, a key=value connection string, or empty:
+
+  - ${NAME} references are replaced with the value of environment variables,
+  - a service name, given with service=name or the PGSERVICE environment
+    variable, is looked up in the pg_service.conf files and its settings
+    are added to the ones of pguri, which win, see ServiceEnv,
+  - other settings are left to lib/pq, which reads the PG* environment
+    variables (PGHOST, PGUSER, PGPASSWORD, ...) and ~/.pgpass.
+*/
+func resolvePgUri(pguri string, env ServiceEnv) (string, error) {
+	pguri, err := expandEnv(pguri)
+	if err != nil {
+		return "", err
+	}
+
+	conninfo := pguri
+	if isURI(pguri) {
+		u, err := parseURI(pguri)
+		if err != nil {
+			return "", err
+		}
+		if u.Query().Get("service") == "" && env.Service == "" {
+			return pguri, nil
+		}
+		if conninfo, err = pq.ParseURL(pguri); err != nil {
+			return "", fmt.Errorf("Failed to parse connection string '%s': %s\n",
+				redactPgUri(pguri), err)
+		}
+	}
+
+	opts, err := parseConnString(conninfo)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse connection string '%s': %s\n",
+			redactPgUri(pguri), err)
+	}
+
+	service, ok := opts["service"]
+	if !ok {
+		service = env.Service
+	}
+	if service == "" {
+		return pguri, nil
+	}
+	delete(opts, "service")
+
+	settings, err := lookupService(service, env)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range settings {
+		if _, ok := opts[key]; !ok {
+			opts[key] = value
+		}
+	}
+	return formatConnString(opts), nil
+}
+
+// serviceFiles returns the pg_service.conf files where to look for
+// connection services, in order: the PGSERVICEFILE one or
+// ~/.pg_service.conf, and then the PGSYSCONFDIR one.
+func serviceFiles(env ServiceEnv) []string {
+	var files []string
+
+	if env.File != "" {
+		files = append(files, env.File)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".pg_service.conf"))
+	}
+	if env.SysconfDir != "" {
+		files = append(files, filepath.Join(env.SysconfDir, "pg_service.conf"))
+	}
+	return files
+}
+
+// lookupService returns the connection settings of the service found in
+// the first of the serviceFiles that defines it.
+func lookupService(service string, env ServiceEnv) (map[string]string, error) {
+	for _, filename := range serviceFiles(env) {
+		f, err := os.Open(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read service file '%s': %s\n", filename, err)
+		}
+		settings, err := parseServiceFile(f, service)
+		f.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read service file '%s': %s\n", filename, err)
+		}
+		if settings != nil {
+			return settings, nil
+		}
+	}
+	return nil, fmt.Errorf("Connection service '%s' not found\n", service)
+}
+
+/*
+parseServiceFile returns the settings of the service section in the
+pg_service.conf contents read from f, or nil when there's no such section:
+
+	# comment
+	[chinook]
+	host=localhost
+	dbname=chinook
+*/
+func parseServiceFile(f io.Reader, service string) (map[string]string, error) {
+	var settings map[string]string
+	section := ""
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			if settings != nil {
+				return settings, nil
+			}
+			section = line[1 : len(line)-1]
+			if section == service {
+				settings = make(map[string]string)
+			}
+
+		case section == service:
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("syntax error in service %q: %q", service, line)
+			}
+			settings[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return settings, scanner.Err()
+}
+
+// formatConnString returns the key=value connection string for opts, with
+// the keys sorted and the values quoted.
+func formatConnString(opts map[string]string) string {
+	var keys []string
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	var kvs []string
+	for _, key := range keys {
+		kvs = append(kvs, key+"='"+quote.Replace(opts[key])+"'")
+	}
+	return strings.Join(kvs, " ")
+}
+
+// Regular expressions matching the password of a key=value connection
+// string, and of a URI, either in its user info or query parameters. The
+// user info password is only matched with uriPasswordRE when the URI can't
+// be parsed, up to the last @ so that passwords containing @ are redacted.
+var (
+	passwordRE      = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+	uriPasswordRE   = regexp.MustCompile(`^(postgres(?:ql)?://[^:@/]*:).*@`)
+	queryPasswordRE = regexp.MustCompile(`([?&]password=)[^&]*`)
+)
+
+// redactPgUri returns pguri with its password, if any, replaced with xxxxx,
+// so that it can be shown.
+func redactPgUri(pguri string) string {
+	if isURI(pguri) {
+		if u, err := url.Parse(pguri); err == nil {
+			pguri = u.Redacted()
+		} else {
+			pguri = uriPasswordRE.ReplaceAllString(pguri, "${1}xxxxx@")
+		}
+		return queryPasswordRE.ReplaceAllString(pguri, "${1}xxxxx")
+	}
+	return passwordRE.ReplaceAllString(pguri, "${1}xxxxx")
+}
+
+// resolvePgUris changes the pguri of the configuration to override, when
+// set, and resolves the connection strings of the configuration, see
+// resolvePgUri. The ${NAME} references of the template database name are
+// expanded too.
+func (c *config) resolvePgUris(override string, env ServiceEnv) error {
+	var err error
+
+	if override != "" {
+		c.PgUri = override
+	}
+	if c.PgUri, err = resolvePgUri(c.PgUri, env); err != nil {
+		return err
+	}
+	if c.Template, err = expandEnv(c.Template); err != nil {
+		return err
+	}
+	for name, pguri := range c.Targets {
+		if c.Targets[name], err = resolvePgUri(pguri, env); err != nil {
+			return fmt.Errorf("Target '%s': %s", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package regresql
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// isURI returns true when pguri is a postgres:// URI rather than a
+// key=value connection string.
+func isURI(pguri string) bool {
+	return strings.HasPrefix(pguri, "postgres://") ||
+		strings.HasPrefix(pguri, "postgresql://")
+}
+
+// parseURI parses the postgres:// URI pguri, with an error message that
+// doesn't show its password.
+func parseURI(pguri string) (*url.URL, error) {
+	u, err := url.Parse(pguri)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("Failed to parse connection string '%s': %s\n",
+			redactPgUri(pguri), err)
+	}
+	return u, nil
+}
+
+// withDatabase returns pguri changed to connect to the dbname database.
+func withDatabase(pguri string, dbname string) (string, error) {
+	if isURI(pguri) {
+		u, err := parseURI(pguri)
+		if err != nil {
+			return "", err
+		}
+		u.Path = "/" + dbname
+		u.RawPath = ""
+		return u.String(), nil
+	}
+
+	// in key=value connection strings, the last value of a key wins
+	value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(dbname)
+	return strings.TrimSpace(pguri + " dbname='" + value + "'"), nil
+}
+
+// databaseName returns the database name found in pguri, or in the
+// PGDATABASE environment variable, or an empty string when none is set.
+func databaseName(pguri string) (string, error) {
+	dbname := ""
+
+	if isURI(pguri) {
+		u, err := parseURI(pguri)
+		if err != nil {
+			return "", err
+		}
+		dbname = strings.TrimPrefix(u.Path, "/")
+	} else {
+		opts, err := parseConnString(pguri)
+		if err != nil {
+			return "", err
+		}
+		dbname = opts["dbname"]
+	}
+
+	if dbname == "" {
+		dbname = os.Getenv("PGDATABASE")
+	}
+	return dbname, nil
+}
+
+/*
+parseConnString parses a key=value connection string, following the libpq
+rules: spaces around the equal sign are allowed, values may be single
+quoted, and a backslash escapes the next character.
+
+	host=localhost dbname='my db' password = 'it\'s'
+*/
+func parseConnString(conninfo string) (map[string]string, error) {
+	opts := make(map[string]string)
+	s := []rune(conninfo)
+	i := 0
+
+	skipSpaces := func() {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n') {
+			i++
+		}
+	}
+
+	for {
+		skipSpaces()
+		if i >= len(s) {
+			break
+		}
+
+		start := i
+		for i < len(s) && s[i] != '=' && s[i] != ' ' && s[i] != '\t' {
+			i++
+		}
+		key := string(s[start:i])
+		skipSpaces()
+		if i >= len(s) || s[i] != '=' {
+			return nil, fmt.Errorf("missing \"=\" after %q in connection string", key)
+		}
+		i++
+		skipSpaces()
+
+		var value strings.Builder
+		if i < len(s) && s[i] == '\'' {
+			i++
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("unterminated quoted value for %q in connection string", key)
+				}
+				if s[i] == '\'' {
+					i++
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteRune(s[i])
+				i++
+			}
+		} else {
+			for i < len(s) && s[i] != ' ' && s[i] != '\t' && s[i] != '\n' {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteRune(s[i])
+				i++
+			}
+		}
+		opts[key] = value.String()
+	}
+	return opts, nil
+}
+
+/*
+An ephemeralDB is a database created for a single test run as a copy of a
+template database, with:
+
+	CREATE DATABASE regresql_<random> TEMPLATE <template>
+
+PgUri connects to the copy. The copy is created and dropped from a
+connection to the postgres maintenance database, as PostgreSQL refuses to
+copy a template database that has other sessions connected to it.
+*/
+type ephemeralDB struct {
+	Name     string
+	Template string
+	PgUri    string
+	adminUri string
+}
+
+// createEphemeralDB creates a copy of the template database, on the server
+// pguri connects to, and returns it. When template is empty, the database
+// of pguri is copied.
+func createEphemeralDB(pguri string, template string) (*ephemeralDB, error) {
+	var err error
+
+	if template == "" {
+		if template, err = databaseName(pguri); err != nil {
+			return nil, err
+		}
+		if template == "" {
+			return nil, fmt.Errorf("Failed to find the template database in '%s', use --template\n",
+				redactPgUri(pguri))
+		}
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	edb := &ephemeralDB{Name: "regresql_" + hex.EncodeToString(suffix), Template: template}
+
+	if edb.adminUri, err = withDatabase(pguri, "postgres"); err != nil {
+		return nil, err
+	}
+	if edb.PgUri, err = withDatabase(pguri, edb.Name); err != nil {
+		return nil, err
+	}
+
+	create := fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
+		pq.QuoteIdentifier(edb.Name), pq.QuoteIdentifier(edb.Template))
+	if err := edb.exec(create); err != nil {
+		return nil, fmt.Errorf("Failed to create database '%s' from template '%s': %s\n",
+			edb.Name, edb.Template, err)
+	}
+	return edb, nil
+}
+
+// Drop drops the ephemeral database.
+func (edb *ephemeralDB) Drop() error {
+	drop := fmt.Sprintf("DROP DATABASE IF EXISTS %s", pq.QuoteIdentifier(edb.Name))
+	if err := edb.exec(drop); err != nil {
+		return fmt.Errorf("Failed to drop database '%s': %s\n", edb.Name, err)
+	}
+	return nil
+}
+
+// exec runs the given command on the maintenance database.
+func (edb *ephemeralDB) exec(command string) error {
+	db, err := sql.Open("postgres", edb.adminUri)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(command)
+	return err
+}
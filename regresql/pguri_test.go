@@ -0,0 +1,50 @@
+package regresql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithDatabase(t *testing.T) {
+	tests := []struct {
+		pguri, dbname, expected string
+	}{
+		{"postgres:///chinook?sslmode=disable", "regresql_1", "postgres:///regresql_1?sslmode=disable"},
+		{"postgresql://user@localhost:5433/chinook", "postgres", "postgresql://user@localhost:5433/postgres"},
+		{"host=localhost dbname=chinook", "regresql_1", "host=localhost dbname=chinook dbname='regresql_1'"},
+	}
+	for _, test := range tests {
+		got, err := withDatabase(test.pguri, test.dbname)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if got != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, got)
+		}
+		if dbname, _ := databaseName(got); dbname != test.dbname {
+			t.Errorf("Expected database %q in %q, got %q", test.dbname, got, dbname)
+		}
+	}
+}
+
+func TestParseConnString(t *testing.T) {
+	opts, err := parseConnString(`host=localhost  dbname = 'my db' password='it\'s' user=a\ b`)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := map[string]string{
+		"host":     "localhost",
+		"dbname":   "my db",
+		"password": "it's",
+		"user":     "a b",
+	}
+	if !reflect.DeepEqual(opts, expected) {
+		t.Errorf("Expected %v, got %v", expected, opts)
+	}
+
+	for _, conninfo := range []string{"host", "dbname='unterminated"} {
+		if _, err := parseConnString(conninfo); err == nil {
+			t.Errorf("Expected an error for %q, got nil", conninfo)
+		}
+	}
+}
@@ -1,16 +1,18 @@
 package regresql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
-	"github.com/theherk/viper" // fork with write support
 	"gopkg.in/yaml.v2"
 )
 
@@ -19,20 +21,29 @@ A query plan associates a Query parsed from a Path (name of the file on
 disk) and a list of set of parameters used to run the query. Each set of
 parameters as a name in Names[i] and a list of bindings in Bindings[i]. When
 the query is executed we store its output in ResultSets[i].
+
+Options are set with SetOptions() before executing the Plan, from the query
+directives and then the Directives found in the plan file, under the
+reserved regresql key:
+
+	regresql:
+	  unordered: true
+	"1":
+	  name: value
 */
 type Plan struct {
 	Query      *Query
 	Path       string // the file path where we read the Plan from
 	Names      []string
-	Bindings   []map[string]string
+	Bindings   []map[string]interface{}
 	ResultSets []ResultSet
+	Options    Options
+	Directives []Directive // options set in the plan file
 }
 
 // CreateEmptyPlan creates a YAML file where to store the set of parameters
 // associated with a query.
 func (q *Query) CreateEmptyPlan(dir string) (*Plan, error) {
-	var names []string
-	var bindings []map[string]string
 	pfile := getPlanPath(q, dir)
 
 	if _, err := os.Stat(pfile); !os.IsNotExist(err) {
@@ -40,12 +51,59 @@ func (q *Query) CreateEmptyPlan(dir string) (*Plan, error) {
 		return &p, fmt.Errorf("Plan file '%s' already exists", pfile)
 	}
 
+	plan := q.emptyPlan(pfile)
+	plan.Write()
+
+	return plan, nil
+}
+
+// createEmptyPlans creates the YAML file where to store the set of
+// parameters associated with the queries read from the same SQL file, with
+// a section per named query, see planSection.
+func createEmptyPlans(queries []*Query, dir string) ([]*Plan, error) {
+	if len(queries) == 1 && queries[0].Name == "" {
+		p, err := queries[0].CreateEmptyPlan(dir)
+		return []*Plan{p}, err
+	}
+	pfile := getPlanPath(queries[0], dir)
+
+	if _, err := os.Stat(pfile); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Plan file '%s' already exists", pfile)
+	}
+
+	var plans []*Plan
+	out := yaml.MapSlice{}
+	for _, q := range queries {
+		p := q.emptyPlan(pfile)
+		plans = append(plans, p)
+
+		if len(p.Bindings) > 0 {
+			out = append(out, yaml.MapItem{Key: q.Name, Value: p.testCases()})
+		}
+	}
+	if len(out) == 0 {
+		fmt.Printf("Skipping Plan '%s': queries use no variable\n", pfile)
+		return plans, nil
+	}
+
+	fmt.Printf("Creating Empty Plan '%s'\n", pfile)
+	writePlanFile(pfile, out)
+
+	return plans, nil
+}
+
+// emptyPlan returns a Plan with a single set of parameters for the query
+// variables, filled with their defaults when there are some.
+func (q *Query) emptyPlan(pfile string) *Plan {
+	var names []string
+	var bindings []map[string]interface{}
+
 	if len(q.Vars) > 0 {
 		names = make([]string, 1)
-		bindings = make([]map[string]string, 1)
+		bindings = make([]map[string]interface{}, 1)
 
 		names[0] = "1"
-		bindings[0] = make(map[string]string)
+		bindings[0] = make(map[string]interface{})
 
 		if q.Positional {
 			// For positional queries, pre-fill from \bind defaults.
@@ -68,122 +126,355 @@ func (q *Query) CreateEmptyPlan(dir string) (*Plan, error) {
 		}
 	} else {
 		names = []string{}
-		bindings = []map[string]string{}
+		bindings = []map[string]interface{}{}
 	}
 
-	plan := &Plan{q, pfile, names, bindings, []ResultSet{}}
-	plan.Write()
-
-	return plan, nil
+	return &Plan{q, pfile, names, bindings, []ResultSet{}, Options{}, nil}
 }
 
 // GetPlan instanciates a Plan from a Query, parsing a set of actual
-// parameters when it exists.
+// parameters when it exists. The plan of a named Query is the section of
+// the plan file found under its name, see planSection.
 func (q *Query) GetPlan(planDir string) (*Plan, error) {
 	var plan *Plan
 	pfile := getPlanPath(q, planDir)
 
-	if _, err := os.Stat(pfile); os.IsNotExist(err) {
-		if len(q.Params) == 0 {
-			// No params and no plan file — perfectly valid.
-			return &Plan{q, pfile,
-				[]string{},
-				[]map[string]string{},
-				[]ResultSet{}}, nil
+	data, err := ioutil.ReadFile(pfile)
+	if err != nil && !os.IsNotExist(err) {
+		return plan, fmt.Errorf("Failed to read file '%s': %s\n", pfile, err)
+	}
+
+	var directives []Directive
+	if err == nil && q.Name != "" {
+		// options set for all the queries of the file come first
+		if directives, err = parsePlanDirectives(data); err != nil {
+			return plan, fmt.Errorf("Failed to parse plan '%s': %s\n", pfile, err)
 		}
-		// Can we synthesise a plan from inline defaults?
-		if q.Positional {
-			// Positional mode: every $N must have a \bind default.
-			if len(q.BindDefaults) >= len(q.Vars) {
-				return &Plan{q, pfile,
-					[]string{"1"},
-					[]map[string]string{{}},
-					[]ResultSet{}}, nil
-			}
-		} else {
-			// Named mode: every :varname must have a \set default.
-			allCovered := true
-			for _, varname := range q.Vars {
-				if _, ok := q.Defaults[varname]; !ok {
-					allCovered = false
-					break
-				}
-			}
-			if allCovered {
-				return &Plan{q, pfile,
-					[]string{"1"},
-					[]map[string]string{{}},
-					[]ResultSet{}}, nil
-			}
+		if data, err = planSection(data, q.Name); err != nil {
+			return plan, fmt.Errorf("Failed to parse plan '%s': %s\n", pfile, err)
+		}
+		if data == nil {
+			err = fmt.Errorf("no plan for query %q", q.Name)
+		}
+	}
+
+	if err != nil {
+		if p := q.defaultPlan(pfile); p != nil {
+			p.Directives = directives
+			return p, nil
 		}
 		e := fmt.Errorf("Failed to get plan '%s': %s\n", pfile, err)
 		return plan, e
 	}
 
-	data, err := ioutil.ReadFile(pfile)
+	names, bindings, err := parsePlan(data)
 	if err != nil {
-		return plan, fmt.Errorf("Failed to read file '%s': %s\n", pfile, err)
+		return plan, fmt.Errorf("Failed to parse plan '%s': %s\n", pfile, err)
 	}
 
-	// Parse the plan YAML into a generic map so we can handle both the
-	// named-binding format and the positional-array format:
-	//
-	//   Named (map):
-	//     "test1":
-	//       name: "value"
-	//
-	//   Positional (array):
-	//     "test1":
-	//       - "val1"
-	//       - "val2"
-	var rawPlan map[string]interface{}
-	if err := yaml.Unmarshal(data, &rawPlan); err != nil {
+	sectionDirectives, err := parsePlanDirectives(data)
+	if err != nil {
 		return plan, fmt.Errorf("Failed to parse plan '%s': %s\n", pfile, err)
 	}
+	directives = append(directives, sectionDirectives...)
+
+	return &Plan{q, pfile, names, bindings, []ResultSet{}, Options{}, directives}, nil
+}
+
+// defaultPlan returns the Plan of a Query that has no plan file, or nil
+// when the query parameters can't be bound without one.
+func (q *Query) defaultPlan(pfile string) *Plan {
+	if len(q.Params) == 0 {
+		// No params and no plan file — perfectly valid.
+		return &Plan{q, pfile,
+			[]string{},
+			[]map[string]interface{}{},
+			[]ResultSet{}, Options{}, nil}
+	}
+	// Can we synthesise a plan from inline defaults?
+	if q.Positional {
+		// Positional mode: every $N must have a \bind default.
+		if len(q.BindDefaults) >= len(q.Vars) {
+			return &Plan{q, pfile,
+				[]string{"1"},
+				[]map[string]interface{}{{}},
+				[]ResultSet{}, Options{}, nil}
+		}
+		return nil
+	}
+	// Named mode: every :varname must have a \set default.
+	for _, varname := range q.Vars {
+		if _, ok := q.Defaults[varname]; !ok {
+			return nil
+		}
+	}
+	return &Plan{q, pfile,
+		[]string{"1"},
+		[]map[string]interface{}{{}},
+		[]ResultSet{}, Options{}, nil}
+}
+
+/*
+planSection returns the section of the plan YAML data found under the name
+key, as YAML data, or nil when there's none. The plan file of a SQL file
+holding several named queries has a section per query, each of them
+written as the plan of a single query, and the options set under the
+planOptionsKey key apply to all the queries:
+
+	regresql:
+	  unordered: true
+	list-albums-by-artist:
+	  "1":
+	    artist: AC/DC
+*/
+func planSection(data []byte, name string) ([]byte, error) {
+	var rawPlan yaml.MapSlice
+	if err := yaml.Unmarshal(data, &rawPlan); err != nil {
+		return nil, err
+	}
+	for _, item := range rawPlan {
+		if fmt.Sprintf("%v", item.Key) != name {
+			continue
+		}
+		if item.Value == nil {
+			return []byte("{}"), nil
+		}
+		if _, ok := item.Value.(yaml.MapSlice); !ok {
+			return nil, fmt.Errorf("query %q expects a mapping of test cases, got %v",
+				name, item.Value)
+		}
+		return yaml.Marshal(item.Value)
+	}
+	return nil, nil
+}
+
+// SetOptions computes the Plan options from the given defaults, usually
+// read from the regress.yaml configuration file, the directives found in
+// the query file, and then the ones found in the plan file.
+func (p *Plan) SetOptions(defaults Options) error {
+	opts, err := p.Query.resolveOptions(defaults)
+	if err != nil {
+		return err
+	}
+	for _, d := range p.Directives {
+		if err := opts.apply(d); err != nil {
+			return fmt.Errorf("%s: %s", p.Path, err)
+		}
+	}
+	p.Options = opts
+	return nil
+}
+
+// query runs the given query with args against db, within a transaction
+// that is rolled back when the Plan Rollback option is set, and registers
+// how long it took in the ResultSet, and its plan when the Explain option
+// is set.
+//
+// The query runs on a dedicated connection, where the Settings, Role and
+// Timeout options are applied first, and reset afterwards. The query is
+// canceled when ctx is done, or when it runs past the Timeout, in which case
+// a *timeoutError is returned. The errors of the query statement itself are
+// returned as a *queryError.
+func (p *Plan) query(parent context.Context, db *sql.DB, query string, args ...interface{}) (res *ResultSet, err error) {
+	ctx, cancel := p.Options.queryContext(parent)
+	defer cancel()
+	defer func() { err = p.Options.checkTimeout(parent, err) }()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// reset the session even when ctx is canceled
+	defer p.Options.resetSession(context.Background(), conn)
+	if err := p.Options.setSession(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	if p.Options.Rollback {
+		res, err = queryTx(ctx, conn, query, args...)
+	} else {
+		res, err = queryResultSet(ctx, conn, query, args...)
+	}
+	if err != nil {
+		return nil, &queryError{err}
+	}
+	res.Duration = time.Since(start)
 
-	var bindings []map[string]string
+	if p.Options.Explain {
+		if res.Explain, err = explainQuery(ctx, conn, query, args...); err != nil {
+			return nil, fmt.Errorf("Failed to explain query: %s", err)
+		}
+	}
+	return res, nil
+}
+
+// parsePlan parses the plan YAML data and returns the test case names and
+// their bindings, in the order they appear in the file. We handle both the
+// named-binding format and the positional-array format:
+//
+//	Named (map):
+//	  "test1":
+//	    name: "value"
+//
+//	Positional (array):
+//	  "test1":
+//	    - "val1"
+//	    - "val2"
+//
+// The YAML is decoded into a yaml.MapSlice rather than a Go map, so that the
+// test cases, and thus the tests numbering, are stable from a run to the
+// next.
+//
+// The expect_error key of a named-binding test case is not a parameter, it
+// is parsed as an *ExpectedError, see planExpectedError.
+func parsePlan(data []byte) ([]string, []map[string]interface{}, error) {
+	var rawPlan yaml.MapSlice
+	if err := yaml.Unmarshal(data, &rawPlan); err != nil {
+		return nil, nil, err
+	}
+
+	var bindings []map[string]interface{}
 	var names []string
+	seen := make(map[string]bool)
+
+	for _, item := range rawPlan {
+		tcName := fmt.Sprintf("%v", item.Key)
+		if tcName == planOptionsKey {
+			continue
+		}
+		if seen[tcName] {
+			return nil, nil, fmt.Errorf("duplicate test case name %q", tcName)
+		}
+		seen[tcName] = true
 
-	for tcName, tcVal := range rawPlan {
 		names = append(names, tcName)
-		bm := make(map[string]string)
+		bm := make(map[string]interface{})
 
-		switch v := tcVal.(type) {
-		case map[interface{}]interface{}:
+		switch v := item.Value.(type) {
+		case yaml.MapSlice:
 			// Named-binding format
-			for k, val := range v {
-				bm[fmt.Sprintf("%v", k)] = fmt.Sprintf("%v", val)
+			for _, param := range v {
+				if fmt.Sprintf("%v", param.Key) == expectErrorKey {
+					e, err := planExpectedError(param.Value)
+					if err != nil {
+						return nil, nil, fmt.Errorf("test case %q: %s", tcName, err)
+					}
+					bm[expectErrorKey] = e
+					continue
+				}
+				val, err := planValue(param.Value)
+				if err != nil {
+					return nil, nil, fmt.Errorf("test case %q: parameter %v: %s",
+						tcName, param.Key, err)
+				}
+				bm[fmt.Sprintf("%v", param.Key)] = val
 			}
 		case []interface{}:
 			// Positional-array format: index 0 -> p1, index 1 -> p2, …
-			for idx, val := range v {
-				bm[fmt.Sprintf("p%d", idx+1)] = fmt.Sprintf("%v", val)
+			for idx, e := range v {
+				val, err := planValue(e)
+				if err != nil {
+					return nil, nil, fmt.Errorf("test case %q: parameter $%d: %s",
+						tcName, idx+1, err)
+				}
+				bm[fmt.Sprintf("p%d", idx+1)] = val
 			}
 		}
 		bindings = append(bindings, bm)
 	}
+	return names, bindings, nil
+}
+
+// planOptionsKey is the plan file key where to set options, rather than a
+// test case.
+const planOptionsKey = "regresql"
+
+// parsePlanDirectives returns the options set in the plan YAML data under
+// the planOptionsKey key, as a list of directives, so that
+//
+//	regresql:
+//	  unordered: true
+//
+// is the same as a `-- regresql: unordered true` query file header. Options
+// given as a mapping are passed as key=value arguments, so that
+//
+//	regresql:
+//	  set:
+//	    TimeZone: UTC
+//
+// is the same as a `-- regresql: set TimeZone=UTC` header.
+func parsePlanDirectives(data []byte) ([]Directive, error) {
+	var rawPlan yaml.MapSlice
+	if err := yaml.Unmarshal(data, &rawPlan); err != nil {
+		return nil, err
+	}
 
-	return &Plan{q, pfile, names, bindings, []ResultSet{}}, nil
+	var directives []Directive
+	for _, item := range rawPlan {
+		if fmt.Sprintf("%v", item.Key) != planOptionsKey {
+			continue
+		}
+		opts, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			return nil, fmt.Errorf("%s expects a mapping of options, got %v",
+				planOptionsKey, item.Value)
+		}
+		for _, opt := range opts {
+			d := Directive{Name: fmt.Sprintf("%v", opt.Key)}
+			switch v := opt.Value.(type) {
+			case nil:
+			case []interface{}:
+				for _, arg := range v {
+					d.Args = append(d.Args, fmt.Sprintf("%v", arg))
+				}
+			case yaml.MapSlice:
+				for _, kv := range v {
+					d.Args = append(d.Args, fmt.Sprintf("%v=%v", kv.Key, kv.Value))
+				}
+			default:
+				d.Args = []string{fmt.Sprintf("%v", v)}
+			}
+			directives = append(directives, d)
+		}
+	}
+	return directives, nil
 }
 
-// Executes a plan and returns the filepath where the output has been
-// written, for later comparing
+// Executes a plan and stores the output of each binding in the ResultSets,
+// for later comparing. A binding that fails to run doesn't stop the others:
+// its ResultSet only has the Error, and the first of those errors is
+// returned.
 func (p *Plan) Execute(db *sql.DB) error {
+	return p.ExecuteContext(context.Background(), db)
+}
+
+// ExecuteContext is like Execute, canceling the query in flight when ctx is
+// done, in which case the remaining bindings are not run and ctx.Err() is
+// returned. A binding that runs past the Timeout option has TimedOut set in
+// its ResultSet.
+func (p *Plan) ExecuteContext(ctx context.Context, db *sql.DB) error {
+	var first error
+
 	if len(p.Query.Params) == 0 {
 		// this Query has no plans, so don't loop over the bindings
 		args := make([]interface{}, 0)
-		res, err := QueryDB(db, p.Query.Query, args...)
+		res, err := p.run(ctx, db, p.expectedError(0), p.Query.Query, args...)
 
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if err != nil {
-			e := fmt.Errorf("Error executing query: %s\n%s\n",
+			res = &ResultSet{Error: formatQueryError(err, p.Query.Query)}
+			_, res.TimedOut = err.(*timeoutError)
+			first = fmt.Errorf("Error executing query: %s\n%s\n",
 				err,
 				p.Query.Query)
-			return e
 		}
-		result := make([]ResultSet, 1)
-		result[0] = *res
-		p.ResultSets = result
-		return nil
+		p.ResultSets = []ResultSet{*res}
+		return first
 	}
 
 	// general case, with a plan and a set of Bindings to go through
@@ -192,32 +483,60 @@ func (p *Plan) Execute(db *sql.DB) error {
 	for i, bindings := range p.Bindings {
 		sql, args, err := p.Query.Prepare(bindings)
 		if err != nil {
-			return fmt.Errorf("Error preparing query '%s': %s", p.Query.Path, err)
+			result[i].Error = fmt.Sprintf("Error preparing query: %s\n", err)
+			if first == nil {
+				first = fmt.Errorf("Error preparing query '%s': %s", p.Query.Path, err)
+			}
+			continue
 		}
-		res, err := QueryDB(db, sql, args...)
+		res, err := p.run(ctx, db, p.expectedError(i), sql, args...)
 
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if err != nil {
-			e := fmt.Errorf(
-				"Error executing query with params: %v\n%s\n%s",
-				args,
-				err,
-				sql)
-			return e
+			result[i].Error = formatQueryError(err, sql)
+			_, result[i].TimedOut = err.(*timeoutError)
+			if first == nil {
+				first = fmt.Errorf(
+					"Error executing query with params: %v\n%s\n%s",
+					args,
+					err,
+					sql)
+			}
+			continue
 		}
 		result[i] = *res
 	}
 	p.ResultSets = result
-	return nil
+	return first
 }
 
 // WriteResultSets serialize the result of running a query, as a Pretty
 // Printed output (comparable to a simplified `psql` output).
 //
 // When pgMajor > 0 the output files use a version-specific suffix
-// (e.g. query.pg16.out) instead of the generic query.out name.
+// (e.g. query.pg16.out) instead of the generic query.out name. Volatile
+// values are replaced following the Mask options, and when the Unordered
+// option is set, the rows are sorted so that the output doesn't depend on
+// the order PostgreSQL returns them in. The query plans, if any, are written
+// in .explain files next to the result files. Nothing is written for the
+// bindings that failed to run.
 func (p *Plan) WriteResultSets(dir string, pgMajor int) error {
 	for i, rs := range p.ResultSets {
 		rsFileName := getResultSetPath(p, dir, i, pgMajor)
+		p.ResultSets[i].Filename = rsFileName
+
+		if rs.Error != "" {
+			// the query failed to run, there's nothing to write
+			continue
+		}
+		if err := rs.Mask(p.Options.Mask); err != nil {
+			return fmt.Errorf("Failed to mask result set '%s': %s\n", rsFileName, err)
+		}
+		if p.Options.Unordered {
+			rs.SortRows()
+		}
 		err := rs.Write(rsFileName, true)
 
 		if err != nil {
@@ -227,20 +546,37 @@ func (p *Plan) WriteResultSets(dir string, pgMajor int) error {
 				err)
 			return e
 		}
-		p.ResultSets[i].Filename = rsFileName
+		if rs.Explain != "" {
+			if err := rs.writeExplain(rsFileName); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-// Write a plan to disk in YAML format.
+// removeErrored removes the result files of the bindings that failed to
+// run, as left by a previous run, so that they aren't mistaken for the
+// current results.
+func (p *Plan) removeErrored() {
+	for _, rs := range p.ResultSets {
+		if rs.Error != "" && rs.Filename != "" {
+			os.Remove(rs.Filename)
+			os.Remove(explainPath(rs.Filename))
+		}
+	}
+}
+
+// Write a plan to disk in YAML format, keeping the test cases in the Plan
+// order.
 //
-// For named-mode queries the existing Viper-based writer is used, producing:
+// For named-mode queries the bindings are written as a YAML map, following
+// the order of the query variables:
 //
 //	"1":
 //	  varname: value
 //
-// For positional-mode queries the plan is written as a YAML array directly
-// via gopkg.in/yaml.v2, producing:
+// For positional-mode queries the plan is written as a YAML array:
 //
 //	"1":
 //	  - value1
@@ -252,51 +588,63 @@ func (p *Plan) Write() {
 	}
 
 	fmt.Printf("Creating Empty Plan '%s'\n", p.Path)
+	writePlanFile(p.Path, p.testCases())
+}
 
-	if p.Query.Positional {
-		// Build an ordered map of test-case-name -> []string for yaml.v2.
-		// yaml.v2 marshals map[string][]string with keys in insertion order
-		// only when using yaml.MapSlice; use a slice of structs instead.
-		type yamlEntry struct {
-			Name   string
-			Values []string
-		}
-		entries := make([]yamlEntry, len(p.Names))
-		for i, name := range p.Names {
-			vals := make([]string, len(p.Query.Vars))
+// testCases returns the test cases of the Plan as written in its file.
+func (p *Plan) testCases() yaml.MapSlice {
+	out := yaml.MapSlice{}
+	for i, name := range p.Names {
+		if p.Query.Positional {
+			vals := make([]interface{}, len(p.Query.Vars))
 			for j, varname := range p.Query.Vars {
 				vals[j] = p.Bindings[i][varname]
 			}
-			entries[i] = yamlEntry{name, vals}
+			out = append(out, yaml.MapItem{Key: name, Value: vals})
+		} else {
+			out = append(out, yaml.MapItem{Key: name, Value: p.namedBindings(i)})
 		}
+	}
+	return out
+}
 
-		// Marshal as a YAML mapping with array values.
-		out := yaml.MapSlice{}
-		for _, e := range entries {
-			out = append(out, yaml.MapItem{Key: e.Name, Value: e.Values})
-		}
-		data, err := yaml.Marshal(out)
-		if err != nil {
-			fmt.Printf("Error marshalling positional plan '%s': %s\n", p.Path, err)
-			return
-		}
-		if err := ioutil.WriteFile(p.Path, data, 0644); err != nil {
-			fmt.Printf("Error writing positional plan '%s': %s\n", p.Path, err)
-		}
+// writePlanFile writes the plan contents to filename in YAML format.
+func writePlanFile(filename string, contents yaml.MapSlice) {
+	data, err := yaml.Marshal(contents)
+	if err != nil {
+		fmt.Printf("Error marshalling plan '%s': %s\n", filename, err)
 		return
 	}
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		fmt.Printf("Error writing plan '%s': %s\n", filename, err)
+	}
+}
 
-	// Named mode: use viper (fork with write support).
-	v := viper.New()
-	v.SetConfigType("yaml")
+// namedBindings returns the i-th bindings of the Plan as a yaml.MapSlice,
+// with the query variables first, in the query order, followed by any other
+// binding sorted by name.
+func (p *Plan) namedBindings(i int) yaml.MapSlice {
+	var ms yaml.MapSlice
+	done := make(map[string]bool)
+
+	for _, varname := range p.Query.Vars {
+		if value, ok := p.Bindings[i][varname]; ok {
+			ms = append(ms, yaml.MapItem{Key: varname, Value: value})
+			done[varname] = true
+		}
+	}
 
-	for i, bindings := range p.Bindings {
-		for key, value := range bindings {
-			vpath := fmt.Sprintf("%s.%s", p.Names[i], key)
-			v.Set(vpath, value)
+	var others []string
+	for key := range p.Bindings[i] {
+		if !done[key] {
+			others = append(others, key)
 		}
 	}
-	v.WriteConfigAs(p.Path)
+	sort.Strings(others)
+	for _, key := range others {
+		ms = append(ms, yaml.MapItem{Key: key, Value: p.Bindings[i][key]})
+	}
+	return ms
 }
 
 func getPlanPath(q *Query, targetdir string) string {
@@ -310,16 +658,21 @@ func getPlanPath(q *Query, targetdir string) string {
 func getResultSetPath(p *Plan, targetdir string, index int, pgMajor int) string {
 	var rsFileName string
 	basename := strings.TrimSuffix(filepath.Base(p.Path), path.Ext(p.Path))
+	if p.Query.Name != "" {
+		basename = basename + "." + p.Query.Name
+	}
 
 	versionSuffix := ""
 	if pgMajor > 0 {
 		versionSuffix = fmt.Sprintf(".pg%d", pgMajor)
 	}
 
+	ext := p.Options.resultExt()
+
 	if len(p.Query.Params) == 0 {
-		rsFileName = fmt.Sprintf("%s%s.out", basename, versionSuffix)
+		rsFileName = fmt.Sprintf("%s%s%s", basename, versionSuffix, ext)
 	} else {
-		rsFileName = fmt.Sprintf("%s.%s%s.out", basename, p.Names[index], versionSuffix)
+		rsFileName = fmt.Sprintf("%s.%s%s%s", basename, p.Names[index], versionSuffix, ext)
 	}
 	return filepath.Join(targetdir, rsFileName)
 }
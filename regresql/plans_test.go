@@ -0,0 +1,154 @@
+package regresql
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePlanKeepsOrder(t *testing.T) {
+	data := []byte(`"top-3":
+  limit: 3
+"top-1":
+  limit: 1
+"all":
+  limit: 100
+"10":
+  limit: 10
+`)
+	names, bindings, err := parsePlan(data)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expected := []string{"top-3", "top-1", "all", "10"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected names %v, got %v", expected, names)
+	}
+	if bindings[1]["limit"] != "1" {
+		t.Errorf("Expected bindings[1][\"limit\"]==\"1\", got %v", bindings[1])
+	}
+}
+
+func TestParsePlanPositional(t *testing.T) {
+	names, bindings, err := parsePlan([]byte("\"1\":\n  - 3\n  - foo\n"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(names) != 1 || bindings[0]["p1"] != "3" || bindings[0]["p2"] != "foo" {
+		t.Errorf("Expected p1==\"3\" and p2==\"foo\", got %v %v", names, bindings)
+	}
+}
+
+func TestParsePlanDuplicateName(t *testing.T) {
+	if _, _, err := parsePlan([]byte("\"1\":\n  limit: 1\n\"1\":\n  limit: 2\n")); err == nil {
+		t.Error("Expected error for duplicate test case name, got nil")
+	}
+}
+
+func TestPlanWriteKeepsOrder(t *testing.T) {
+	q := mustParseQueryString(t, "src/sql/q.sql", "SELECT :zeta, :alpha;\n")
+	p := &Plan{
+		Query:    q,
+		Path:     filepath.Join(t.TempDir(), "q.yaml"),
+		Names:    []string{"second", "first"},
+		Bindings: []map[string]interface{}{{"zeta": "z2", "alpha": "a2"}, {"zeta": "z1", "alpha": "a1"}},
+	}
+	p.Write()
+
+	data, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := "second:\n  zeta: z2\n  alpha: a2\nfirst:\n  zeta: z1\n  alpha: a1\n"
+	if string(data) != expected {
+		t.Errorf("Expected plan file:\n%s\ngot:\n%s", expected, data)
+	}
+
+	names, _, err := parsePlan(data)
+	if err != nil || strings.Join(names, ",") != "second,first" {
+		t.Errorf("Expected names [second first] when reading back, got %v (%v)", names, err)
+	}
+}
+
+func TestPlanOptions(t *testing.T) {
+	data := []byte("regresql:\n  unordered: true\n\"1\":\n  limit: 1\n")
+
+	names, _, err := parsePlan(data)
+	if err != nil || strings.Join(names, ",") != "1" {
+		t.Errorf("Expected names [1], got %v (%v)", names, err)
+	}
+
+	directives, err := parsePlanDirectives(data)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	q := mustParseQueryString(t, "src/sql/q.sql", "-- regresql: rollback\nSELECT :limit;\n")
+	p := &Plan{Query: q, Directives: directives}
+	if err := p.SetOptions(Options{}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !p.Options.Unordered || !p.Options.Rollback {
+		t.Errorf("Expected Unordered and Rollback options, got %+v", p.Options)
+	}
+
+	if _, err := parsePlanDirectives([]byte("regresql: true\n")); err == nil {
+		t.Error("Expected error for options that are not a mapping, got nil")
+	}
+}
+
+func TestNamedQueryPlans(t *testing.T) {
+	dir := t.TempDir()
+	queries, err := parseQueries(filepath.Join("src/sql", "queries.sql"),
+		"-- name: list-artists\nselect name from artist;\n\n"+
+			"-- name: list-albums-by-artist\nselect title from album where artist = :name;\n")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if _, err := createEmptyPlans(queries, dir); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "queries.yaml"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected := "list-albums-by-artist:\n  \"1\":\n    name: \"\"\n"; string(data) != expected {
+		t.Errorf("Expected plan file:\n%s\ngot:\n%s", expected, data)
+	}
+
+	plan := "regresql:\n  unordered: true\nlist-albums-by-artist:\n  regresql:\n    rollback: true\n" +
+		"  ac-dc:\n    name: AC/DC\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "queries.yaml"), []byte(plan), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for _, q := range queries {
+		p, err := q.GetPlan(dir)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if err := p.SetOptions(Options{}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if !p.Options.Unordered {
+			t.Errorf("Expected the file options to apply to %s", q.Name)
+		}
+		if q.Name == "list-albums-by-artist" && !p.Options.Rollback {
+			t.Errorf("Expected the section options to apply to %s", q.Name)
+		}
+		for i := range p.Names {
+			paths = append(paths, getResultSetPath(p, "", i, 0))
+		}
+		if len(p.Names) == 0 {
+			paths = append(paths, getResultSetPath(p, "", 0, 0))
+		}
+	}
+
+	expected := []string{"queries.list-artists.out", "queries.list-albums-by-artist.ac-dc.out"}
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected result files %v, got %v", expected, paths)
+	}
+}
@@ -0,0 +1,71 @@
+package regresql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+/*
+formatQueryError returns the error message of running query, in the same
+format as psql, so that the SQLSTATE code, the hint and the offending line
+of SQL are reported along with the message:
+
+	ERROR:  22P02: invalid input syntax for type integer: "abc"
+	LINE 2:  where id = 'abc'::int
+	                    ^
+
+Errors that are not PostgreSQL errors are returned as is.
+*/
+func formatQueryError(err error, query string) string {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err.Error() + "\n"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:  %s: %s\n", pqErr.Severity, pqErr.Code, pqErr.Message)
+	if pqErr.Detail != "" {
+		fmt.Fprintf(&b, "DETAIL:  %s\n", pqErr.Detail)
+	}
+	if pqErr.Hint != "" {
+		fmt.Fprintf(&b, "HINT:  %s\n", pqErr.Hint)
+	}
+	if pos, err := strconv.Atoi(pqErr.Position); err == nil {
+		b.WriteString(errorLine(query, pos))
+	}
+	if pqErr.InternalQuery != "" {
+		fmt.Fprintf(&b, "QUERY:  %s\n", pqErr.InternalQuery)
+	}
+	if pqErr.Where != "" {
+		fmt.Fprintf(&b, "CONTEXT:  %s\n", pqErr.Where)
+	}
+	return b.String()
+}
+
+// errorLine returns the line of query where the error at position is, with
+// a caret under the position, which PostgreSQL counts in characters from 1.
+func errorLine(query string, pos int) string {
+	runes := []rune(query)
+	if pos < 1 || pos > len(runes)+1 {
+		return ""
+	}
+
+	start := pos - 1
+	for start > 0 && runes[start-1] != '\n' {
+		start--
+	}
+	end := pos - 1
+	for end < len(runes) && runes[end] != '\n' {
+		end++
+	}
+	lineno := strings.Count(string(runes[:start]), "\n") + 1
+
+	prefix := fmt.Sprintf("LINE %d: ", lineno)
+	line := strings.TrimRight(string(runes[start:end]), "\r")
+	caret := strings.Repeat(" ", len(prefix)+pos-1-start) + "^"
+	return prefix + line + "\n" + caret + "\n"
+}
@@ -0,0 +1,77 @@
+package regresql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestFormatQueryError(t *testing.T) {
+	query := "select title\n  from album\n where albumid = 'abc'::int;\n"
+	err := &pq.Error{
+		Severity: "ERROR",
+		Code:     "22P02",
+		Message:  `invalid input syntax for type integer: "abc"`,
+		Hint:     "Use a number.",
+		Position: "44",
+	}
+
+	expected := `ERROR:  22P02: invalid input syntax for type integer: "abc"
+HINT:  Use a number.
+LINE 3:  where albumid = 'abc'::int;
+                         ^
+`
+	if got := formatQueryError(err, query); got != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, got)
+	}
+
+	if got := formatQueryError(errors.New("driver: bad connection"), query); got != "driver: bad connection\n" {
+		t.Errorf("Expected the error as is, got %q", got)
+	}
+}
+
+func TestCompareErroredResultSet(t *testing.T) {
+	dir := t.TempDir()
+	q := mustParseQueryString(t, "src/sql/artist.sql", "SELECT :id::int;\n")
+	p := &Plan{Query: q, Path: "regresql/plans/src/sql/artist.yaml",
+		Names:    []string{"1", "2"},
+		Bindings: []map[string]interface{}{{"id": "abc"}, {"id": "1"}},
+		ResultSets: []ResultSet{
+			{Error: "ERROR:  22P02: invalid input syntax\n"},
+			{Cols: []string{"int4"}, Rows: [][]interface{}{{int64(1)}}},
+		},
+	}
+
+	if err := p.WriteResultSets(dir, 0); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "artist.1.out")); !os.IsNotExist(err) {
+		t.Errorf("Expected no result file for the errored binding, got %v", err)
+	}
+
+	var b bytes.Buffer
+	r, _ := NewReporter("json", &b)
+	// the expected file of the second binding is missing
+	failed, errored := p.CompareResultSets(dir, filepath.Join(dir, "expected"), r, 0)
+	if failed != 0 || errored != 2 {
+		t.Errorf("Expected 2 errored tests, got %d failed and %d errored", failed, errored)
+	}
+	if err := r.Finish(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(b.Bytes(), &report); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	result := report.Results[0]
+	if report.Errored != 2 || result.Status != "errored" || !strings.Contains(result.Error, "22P02") {
+		t.Errorf("Expected the errored binding in the report, got %+v", report)
+	}
+}
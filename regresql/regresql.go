@@ -4,8 +4,29 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+// RunOptions are the command line settings of the update and test
+// commands.
+type RunOptions struct {
+	Jobs      int    // how many query files to run concurrently
+	Format    string // test report format, one of ReportFormats
+	Output    string // test report file, defaults to standard output
+	Ephemeral bool   // run the tests in a copy of the Template database
+	Template  string // template database, defaults to the pguri one
+	Keep      bool   // don't drop the ephemeral database at the end
+	Target    string // comma separated target names, or "all"
+	PgUri     string // connection string overriding the pguri setting
+	Watch     bool   // run the tests again when files change
+
+	// connection service settings to resolve the connection strings with
+	Service ServiceEnv
+
+	// query timeout overriding the timeout setting, when > 0
+	Timeout time.Duration
+}
+
 // resolveRoot returns the directory that Walk should scan for SQL files.
 // When regress.yaml sets root to a non-trivial value (not "" or "."), that
 // value is interpreted as a path relative to the suite root (the directory
@@ -24,10 +45,19 @@ Init initializes a code repository for RegreSQL processing.
 That means creating the ./regresql/ directory, walking the code repository
 in search of *.sql files, and creating the associated empty plan files. If
 the plan files already exists, we simply skip them, thus allowing to run
-init again on an existing repository to create missing plan files.
+init again on an existing repository to create missing plan files. The
+pguri connection string is resolved with the env service settings.
 */
-func Init(root string, pguri string) {
-	if err := TestConnectionString(pguri); err != nil {
+func Init(root string, pguri string, env ServiceEnv) {
+	// keep the ${NAME} references in regress.yaml, only test the
+	// resolved connection string
+	resolved, err := resolvePgUri(pguri, env)
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(2)
+	}
+
+	if err := TestConnectionString(resolved); err != nil {
 		fmt.Printf(err.Error())
 		os.Exit(2)
 	}
@@ -59,8 +89,10 @@ case and add a value for each parameter.\n `,
 		suite.PlanDir)
 }
 
-// PlanQueries create query plans for queries found in the root repository
-func PlanQueries(root string) {
+// PlanQueries create query plans for queries found in the root repository,
+// checking the connection to pguri, or to the pguri of the configuration
+// file when empty, resolved with the env service settings.
+func PlanQueries(root string, pguri string, env ServiceEnv) {
 	config, err := newSuite(root).readConfig()
 
 	if err != nil {
@@ -68,6 +100,11 @@ func PlanQueries(root string) {
 		os.Exit(3)
 	}
 
+	if err := config.resolvePgUris(pguri, env); err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(3)
+	}
+
 	if err := TestConnectionString(config.PgUri); err != nil {
 		fmt.Printf(err.Error())
 		os.Exit(2)
@@ -97,13 +134,14 @@ case and add a value for each parameter. `)
 }
 
 /*
-Update updates the expected files from the queries and their parameters.
+Update updates the expected files from the queries and their parameters,
+for the tests selected by filter.
 
-versionedPaths lists SQL file paths (relative to root) that should produce
-version-specific expected output (e.g. query.pg16.out). When versionedAll is
-true every file in the suite is treated as versioned.
+The selected queries matching the versioned paths, given as Filter paths
+without test case names, produce version-specific expected output (e.g.
+query.pg16.out) rather than the generic one, "." selecting them all.
 */
-func Update(root string, versionedPaths []string, versionedAll bool) {
+func Update(root string, filter Filter, versioned []string, opts RunOptions) {
 	config, err := newSuite(root).readConfig()
 
 	if err != nil {
@@ -111,6 +149,15 @@ func Update(root string, versionedPaths []string, versionedAll bool) {
 		os.Exit(3)
 	}
 
+	if opts.Timeout > 0 {
+		config.Timeout = opts.Timeout
+	}
+
+	if err := config.resolvePgUris(opts.PgUri, opts.Service); err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(3)
+	}
+
 	if err := TestConnectionString(config.PgUri); err != nil {
 		fmt.Printf(err.Error())
 		os.Exit(2)
@@ -118,24 +165,18 @@ func Update(root string, versionedPaths []string, versionedAll bool) {
 
 	suite := WalkFrom(root, resolveRoot(root, config.Root), config.Exclude)
 
-	versionedFiles := make(map[string]bool)
-	if versionedAll {
-		for _, folder := range suite.Dirs {
-			for _, name := range folder.Files {
-				versionedFiles[filepath.Join(folder.Dir, name)] = true
-			}
-		}
-	} else {
-		for _, p := range versionedPaths {
-			rel, relErr := filepath.Rel(root, filepath.Join(root, p))
-			if relErr != nil {
-				rel = filepath.Clean(p)
-			}
-			versionedFiles[rel] = true
-		}
+	if err := suite.filter(config, filter); err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(4)
 	}
 
-	if err := suite.createExpectedResults(config.PgUri, versionedFiles); err != nil {
+	versionedFiles, err := suite.versionedFiles(versioned)
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(4)
+	}
+
+	if err := suite.createExpectedResults(interruptContext(), config, target{PgUri: config.PgUri}, opts, versionedFiles); err != nil {
 		fmt.Printf(err.Error())
 		os.Exit(12)
 	}
@@ -158,9 +199,21 @@ the regresql update command again to reset the expected output files.
 
 /*
 Test runs the queries and compare their results to the previously created
-expected files (see Update()), reporting a TAP output to standard output.
+expected files (see Update()), reporting a TAP output to standard output, or
+another format to another file, as set in opts. Only the tests selected by
+filter are run.
+
+When opts.Ephemeral is set, the tests run in a new database created as a
+copy of the template database, which is dropped at the end unless opts.Keep
+is set.
+
+When opts.Target is set, the tests run against each of the selected
+targets of the configuration file in turn, rather than the pguri database.
+
+When opts.Watch is set, the tests run again each time a file changes,
+until interrupted, see watch.
 */
-func Test(root string) {
+func Test(root string, filter Filter, opts RunOptions) {
 	config, err := newSuite(root).readConfig()
 
 	if err != nil {
@@ -168,24 +221,257 @@ func Test(root string) {
 		os.Exit(3)
 	}
 
-	if err := TestConnectionString(config.PgUri); err != nil {
+	if opts.Timeout > 0 {
+		config.Timeout = opts.Timeout
+	}
+
+	if err := config.resolvePgUris(opts.PgUri, opts.Service); err != nil {
 		fmt.Printf(err.Error())
-		os.Exit(2)
+		os.Exit(3)
 	}
 
-	suite := WalkFrom(root, resolveRoot(root, config.Root), config.Exclude)
+	targets, err := config.getTargets(opts.Target)
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(3)
+	}
 
-	if err := suite.testQueries(config.PgUri); err != nil {
-		// *ErrTestsFailed means the TAP output already reported the
-		// failures; just exit 1 so the shell / CI catch them.
-		if _, ok := err.(*ErrTestsFailed); !ok {
+	for _, t := range targets {
+		if err := TestConnectionString(t.PgUri); err != nil {
 			fmt.Printf(err.Error())
+			os.Exit(2)
+		}
+	}
+
+	suite := WalkFrom(root, resolveRoot(root, config.Root), config.Exclude)
+
+	if err := suite.filter(config, filter); err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(4)
+	}
+
+	var edbs []*ephemeralDB
+	dropAll := func() {
+		for _, edb := range edbs {
+			if opts.Keep {
+				fmt.Printf("Kept database '%s'\n", edb.Name)
+			} else if derr := edb.Drop(); derr != nil {
+				fmt.Printf(derr.Error())
+			}
+		}
+	}
+
+	if opts.Ephemeral {
+		template := opts.Template
+		if template == "" {
+			template = config.Template
+		}
+		for i, t := range targets {
+			edb, err := createEphemeralDB(t.PgUri, template)
+			if err != nil {
+				fmt.Printf(err.Error())
+				dropAll()
+				os.Exit(2)
+			}
+			fmt.Printf("Created database '%s' from template '%s'\n", edb.Name, edb.Template)
+			edbs = append(edbs, edb)
+			targets[i].PgUri = edb.PgUri
+		}
+	}
+
+	// Ctrl-C cancels the queries in flight, the ephemeral databases are
+	// still dropped
+	ctx := interruptContext()
+	if opts.Watch {
+		err = suite.watch(ctx, resolveRoot(root, config.Root), filter, config, targets, opts)
+	} else {
+		err = suite.testQueries(ctx, config, targets, opts)
+	}
+	dropAll()
+
+	if err != nil {
+		switch e := err.(type) {
+		case *ErrTestsFailed:
+			// the test report already has the failures; just exit 1,
+			// or 5 when some queries failed to run, so the shell / CI
+			// catch them.
+			if e.Errors > 0 {
+				os.Exit(5)
+			}
+			os.Exit(1)
+
+		case *ErrBailOut:
+			// the test report already has the bail out reason, only
+			// repeat it when the report is written to a file.
+			if opts.Output != "" {
+				fmt.Printf(err.Error())
+			}
 			os.Exit(13)
 		}
+		fmt.Printf(err.Error())
+		os.Exit(13)
+	}
+}
+
+// DiffOptions are the command line settings of the diff command.
+type DiffOptions struct {
+	Context    int  // lines of context in unified diffs
+	SideBySide bool // output in two columns
+	Width      int  // side-by-side output width
+	Stat       bool // only output a summary of the changes
+	Color      bool // colorize the output
+	PgMajor    int  // prefer version-specific expected files, when > 0
+
+	// comma separated target names, or "all", see RunOptions
+	Target string
+}
+
+/*
+Diff compares the actual result files left in regresql/out by Test() with
+the expected ones, without running the queries again. Results are compared
+as Test() does, following the query options such as the tolerance rules,
+and the differences are shown line by line. When paths are given,
+only the result files of the tests they select are compared, see Filter.
+When opts.Target is set, the result files of the selected targets are
+compared rather than the ones of the default target. The results of each
+target are compared to the expected files of its PostgreSQL major version,
+as recorded by Test(), or of opts.PgMajor when set, see resultPairs.
+
+The exit status is 1 when differences are found, as with diff(1).
+*/
+func Diff(root string, paths []string, opts DiffOptions) {
+	suite := newSuite(root)
+	config, err := suite.readConfig()
+
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(3)
+	}
+
+	suite = WalkFrom(root, resolveRoot(root, config.Root), config.Exclude)
+
+	if err := suite.filter(config, Filter{Paths: paths}); err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(4)
+	}
+
+	targets, err := config.getTargets(opts.Target)
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(3)
+	}
+
+	pairs, err := suite.resultPairs(config, targets, opts.PgMajor)
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(4)
+	}
+
+	changed, insertions, deletions := 0, 0, 0
+	var stats []string
+	var counts [][2]int
+
+	for _, pair := range pairs {
+		// a missing expected file shows as all lines added
+		expected, actual, ok, err := pair.readLines()
+		if err != nil {
+			fmt.Printf(err.Error())
+			os.Exit(4)
+		}
+		if !ok {
+			continue
+		}
+		if diff, err := pair.changed(); err != nil {
+			fmt.Printf(err.Error())
+			os.Exit(4)
+		} else if !diff {
+			continue
+		}
+
+		ins, del := DiffStat(expected, actual)
+		changed++
+		insertions += ins
+		deletions += del
+
+		switch {
+		case opts.Stat:
+			stats = append(stats, pair.Name)
+			counts = append(counts, [2]int{ins, del})
+
+		case opts.SideBySide:
+			fmt.Printf("diff %s %s\n", pair.Expected, pair.Actual)
+			fmt.Print(SideBySide(expected, actual, opts.Width, opts.Color))
+
+		default:
+			diff := DiffLines(pair.Expected, pair.Actual, expected, actual, opts.Context)
+			if opts.Color {
+				diff = ColorizeDiff(diff)
+			}
+			fmt.Print(diff)
+		}
+	}
+
+	if opts.Stat {
+		fmt.Print(formatDiffStat(stats, counts, opts.Color))
+		fmt.Printf(" %d files changed, %d insertions(+), %d deletions(-)\n",
+			changed, insertions, deletions)
+	}
+
+	if changed > 0 {
 		os.Exit(1)
 	}
 }
 
+/*
+Accept walks the results of the last Test() run that differ from their
+expected files, shows each diff, and asks whether to accept the actual
+result as the new expected one. With opts.All set every changed result is
+accepted without asking, and opts.Match restricts the results considered to
+those whose name (e.g. src/sql/artist.1.out) matches a glob pattern.
+When opts.Target is set, the results of the selected targets are considered
+rather than the ones of the default target. When opts.PgMajor is set, the
+results are accepted into the version-specific expected files of that
+PostgreSQL major version, e.g. artist.1.pg16.out, created when needed.
+With several targets, the results of each target are accepted into the
+expected files of its own PostgreSQL major version, as recorded by Test().
+*/
+func Accept(root string, paths []string, opts AcceptOptions) {
+	suite := newSuite(root)
+	config, err := suite.readConfig()
+
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(3)
+	}
+
+	suite = WalkFrom(root, resolveRoot(root, config.Root), config.Exclude)
+
+	if err := suite.filter(config, Filter{Paths: paths}); err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(4)
+	}
+
+	targets, err := config.getTargets(opts.Target)
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(3)
+	}
+
+	pairs, err := suite.resultPairs(config, targets, opts.PgMajor)
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(4)
+	}
+
+	accepted, err := acceptPairs(pairs, os.Stdin, os.Stdout, opts)
+	fmt.Printf("Accepted %d result(s)\n", accepted)
+
+	if err != nil {
+		fmt.Printf(err.Error())
+		os.Exit(4)
+	}
+}
+
 // List walks a repository, builds a Suite instance and pretty prints it.
 // When regress.yaml is present and its root field is set, only the files
 // under that subtree are listed — matching what test and update process.
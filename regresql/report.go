@@ -0,0 +1,307 @@
+package regresql
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A TestResult is the outcome of comparing the result set of a query
+// binding with its expected output.
+type TestResult struct {
+	Name         string        // test name, e.g. src/sql/artist.1.out
+	Target       string        // target database name, if any
+	QueryFile    string        // query file path
+	PlanFile     string        // bindings (plan) file path
+	Binding      string        // binding name in the plan, if any
+	Params       interface{}   // binding parameters
+	ExpectedFile string        // expected result file path
+	ActualFile   string        // actual result file path
+	Passed       bool          // true when the result matches the expected one
+	Errored      bool          // true when the query or the comparison failed to run
+	TimedOut     bool          // true when the query was canceled after the timeout
+	Diff         string        // unified diff of expected and actual results
+	Error        string        // error or failure message, if any
+	Duration     time.Duration // time it took to run the query
+	Baseline     time.Duration // duration recorded at update time, if any
+	Slow         string        // performance regression, if any
+}
+
+/*
+A Reporter outputs TestResult values in a given format. Header is called
+once before any result is reported, and Finish once after the last one.
+Streaming formats (TAP) write results as they are reported, document
+formats (JUnit, JSON) write everything in Finish.
+
+BailOut is called when the test run is aborted, before Finish.
+*/
+type Reporter interface {
+	Header()
+	Report(result TestResult)
+	BailOut(reason string)
+	Finish() error
+}
+
+// ReportFormats lists the supported report formats, the first one being the
+// default.
+var ReportFormats = []string{"tap", "junit", "json"}
+
+// NewReporter returns a Reporter writing to w in the given format.
+func NewReporter(format string, w io.Writer) (Reporter, error) {
+	switch format {
+	case "", "tap":
+		return newTapReporter(w), nil
+	case "junit":
+		return &junitReporter{w: w}, nil
+	case "json":
+		return &jsonReporter{w: w}, nil
+	}
+	return nil, fmt.Errorf("Unknown report format '%s', expected one of %v",
+		format, ReportFormats)
+}
+
+// junitReporter outputs test results as a JUnit XML document, with a
+// testsuite element per query file (and target) and a testcase element per
+// binding.
+type junitReporter struct {
+	w       io.Writer
+	results []TestResult
+	bailOut string
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitTime formats d in seconds, as expected in JUnit reports.
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func (r *junitReporter) Header() {}
+
+func (r *junitReporter) Report(result TestResult) {
+	r.results = append(r.results, result)
+}
+
+func (r *junitReporter) BailOut(reason string) {
+	r.bailOut = reason
+}
+
+// Finish writes the JUnit XML document, grouping the test cases by query
+// file in the order they have been reported.
+func (r *junitReporter) Finish() error {
+	doc := junitTestSuites{Name: "regresql"}
+	var total time.Duration
+	var suiteTime time.Duration
+
+	for _, result := range r.results {
+		name := result.QueryFile
+		if result.Target != "" {
+			name = result.Target + "/" + result.QueryFile
+		}
+		n := len(doc.Suites)
+		if n == 0 || doc.Suites[n-1].Name != name {
+			doc.Suites = append(doc.Suites, junitTestSuite{Name: name})
+			n++
+			suiteTime = 0
+		}
+		suite := &doc.Suites[n-1]
+
+		tc := junitTestCase{
+			Name:      result.Name,
+			Classname: name,
+			Time:      junitTime(result.Duration),
+		}
+		switch {
+		case result.Errored:
+			tc.Error = &junitMessage{"Failed to run test", result.Error}
+			suite.Errors++
+			doc.Errors++
+
+		case result.TimedOut:
+			tc.Failure = &junitMessage{"Timeout", result.Error}
+			suite.Failures++
+			doc.Failures++
+
+		case !result.Passed:
+			tc.Failure = &junitMessage{
+				fmt.Sprintf("Result differs from '%s'", result.ExpectedFile),
+				result.Diff,
+			}
+			if result.Diff == "" && result.Error != "" {
+				tc.Failure = &junitMessage{"Unexpected query outcome", result.Error}
+			} else if result.Diff == "" && result.Slow != "" {
+				tc.Failure = &junitMessage{"Performance regression", result.Slow}
+			}
+			suite.Failures++
+			doc.Failures++
+		}
+		suite.Cases = append(suite.Cases, tc)
+		suite.Tests++
+		doc.Tests++
+
+		suiteTime += result.Duration
+		suite.Time = junitTime(suiteTime)
+		total += result.Duration
+	}
+	doc.Time = junitTime(total)
+
+	if r.bailOut != "" {
+		// report the aborted run as an error in a testsuite of its own
+		doc.Suites = append(doc.Suites, junitTestSuite{
+			Name:   "regresql",
+			Tests:  1,
+			Errors: 1,
+			Time:   junitTime(0),
+			Cases: []junitTestCase{{
+				Name:      "bail out",
+				Classname: "regresql",
+				Time:      junitTime(0),
+				Error:     &junitMessage{"Bail out!", r.bailOut},
+			}},
+		})
+		doc.Tests++
+		doc.Errors++
+	}
+
+	if _, err := io.WriteString(r.w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(r.w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("Failed to write JUnit report: %s", err)
+	}
+	_, err := io.WriteString(r.w, "\n")
+	return err
+}
+
+// jsonReporter outputs test results as a JSON document.
+type jsonReporter struct {
+	w       io.Writer
+	results []TestResult
+	bailOut string
+}
+
+type jsonReport struct {
+	Tests    int        `json:"tests"`
+	Passed   int        `json:"passed"`
+	Failed   int        `json:"failed"`
+	Errored  int        `json:"errored"`
+	Duration float64    `json:"duration_ms"`
+	BailOut  string     `json:"bail_out,omitempty"`
+	Results  []jsonTest `json:"results"`
+}
+
+type jsonTest struct {
+	Name         string      `json:"name"`
+	Target       string      `json:"target,omitempty"`
+	Status       string      `json:"status"`
+	QueryFile    string      `json:"query_file"`
+	PlanFile     string      `json:"plan_file,omitempty"`
+	Binding      string      `json:"binding,omitempty"`
+	Params       interface{} `json:"params,omitempty"`
+	ExpectedFile string      `json:"expected_file"`
+	ActualFile   string      `json:"actual_file"`
+	Duration     float64     `json:"duration_ms"`
+	Baseline     float64     `json:"baseline_ms,omitempty"`
+	Slow         string      `json:"slow,omitempty"`
+	Diff         string      `json:"diff,omitempty"`
+	Error        string      `json:"error,omitempty"`
+}
+
+// milliseconds returns d as a floating point number of milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (r *jsonReporter) Header() {}
+
+func (r *jsonReporter) Report(result TestResult) {
+	r.results = append(r.results, result)
+}
+
+func (r *jsonReporter) BailOut(reason string) {
+	r.bailOut = reason
+}
+
+// Finish writes the JSON document.
+func (r *jsonReporter) Finish() error {
+	report := jsonReport{Results: []jsonTest{}, BailOut: r.bailOut}
+	var total time.Duration
+
+	for _, result := range r.results {
+		status := "passed"
+		switch {
+		case result.Errored:
+			status = "errored"
+			report.Errored++
+		case result.TimedOut:
+			status = "timeout"
+			report.Failed++
+		case !result.Passed:
+			status = "failed"
+			report.Failed++
+		default:
+			report.Passed++
+		}
+		report.Tests++
+		total += result.Duration
+
+		report.Results = append(report.Results, jsonTest{
+			Name:         result.Name,
+			Target:       result.Target,
+			Status:       status,
+			QueryFile:    result.QueryFile,
+			PlanFile:     result.PlanFile,
+			Binding:      result.Binding,
+			Params:       result.Params,
+			ExpectedFile: result.ExpectedFile,
+			ActualFile:   result.ActualFile,
+			Duration:     milliseconds(result.Duration),
+			Baseline:     milliseconds(result.Baseline),
+			Slow:         result.Slow,
+			Diff:         result.Diff,
+			Error:        result.Error,
+		})
+	}
+	report.Duration = milliseconds(total)
+
+	enc := json.NewEncoder(r.w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("Failed to write JSON report: %s", err)
+	}
+	return nil
+}
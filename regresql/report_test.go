@@ -0,0 +1,131 @@
+package regresql
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reportResults reports a passing and a failing test to a new Reporter in
+// the given format and returns its output.
+func reportResults(t *testing.T, format string) string {
+	t.Helper()
+	var b bytes.Buffer
+
+	r, err := NewReporter(format, &b)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	r.Header()
+	r.Report(TestResult{
+		Name:      "src/sql/artist.1.out",
+		QueryFile: "src/sql/artist.sql",
+		Binding:   "1",
+		Passed:    true,
+		Duration:  12 * time.Millisecond,
+	})
+	r.Report(TestResult{
+		Name:      "src/sql/genre-topn.top-3.out",
+		QueryFile: "src/sql/genre-topn.sql",
+		Binding:   "top-3",
+		Passed:    false,
+		Diff:      "-Rock\n+Jazz\n",
+		Duration:  3 * time.Millisecond,
+	})
+	if err := r.Finish(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	return b.String()
+}
+
+func TestReporterUnknownFormat(t *testing.T) {
+	if _, err := NewReporter("xunit", &bytes.Buffer{}); err == nil {
+		t.Error("Expected error for unknown format, got nil")
+	}
+}
+
+func TestTapReporter(t *testing.T) {
+	out := reportResults(t, "tap")
+
+	if !strings.HasPrefix(out, "TAP version 13\n") {
+		t.Errorf("Expected TAP version header, got %q", out)
+	}
+	if !strings.Contains(out, "ok 1 - src/sql/artist.1.out\n") {
+		t.Errorf("Expected passing test line, got %q", out)
+	}
+	if !strings.Contains(out, "not ok 2 - src/sql/genre-topn.top-3.out\n") {
+		t.Errorf("Expected failing test line, got %q", out)
+	}
+	if !strings.Contains(out, "# +Jazz") {
+		t.Errorf("Expected diff in diagnostic, got %q", out)
+	}
+	if !strings.Contains(out, "  ---\n  {\n    \"duration_ms\": 12\n  }\n  ...\n") {
+		t.Errorf("Expected duration in a YAML block, got %q", out)
+	}
+	if !strings.HasSuffix(out, "# 1 passed, 1 failed, 0 errored\n") {
+		t.Errorf("Expected a summary diagnostic, got %q", out)
+	}
+}
+
+func TestJUnitReporter(t *testing.T) {
+	out := reportResults(t, "junit")
+
+	var doc junitTestSuites
+	if err := xml.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("Failed to parse JUnit report: %s\n%s", err, out)
+	}
+	if doc.Tests != 2 || doc.Failures != 1 || len(doc.Suites) != 2 {
+		t.Fatalf("Expected 2 tests, 1 failure in 2 suites, got %+v", doc)
+	}
+	failed := doc.Suites[1].Cases[0]
+	if failed.Failure == nil || failed.Failure.Text != "-Rock\n+Jazz\n" {
+		t.Errorf("Expected failure with diff text, got %+v", failed)
+	}
+	if doc.Suites[0].Cases[0].Time != "0.012" {
+		t.Errorf("Expected time 0.012, got %q", doc.Suites[0].Cases[0].Time)
+	}
+}
+
+func TestJSONReporter(t *testing.T) {
+	out := reportResults(t, "json")
+
+	var report jsonReport
+	if err := json.Unmarshal([]byte(out), &report); err != nil {
+		t.Fatalf("Failed to parse JSON report: %s\n%s", err, out)
+	}
+	if report.Tests != 2 || report.Passed != 1 || report.Failed != 1 {
+		t.Errorf("Expected 2 tests, 1 passed and 1 failed, got %+v", report)
+	}
+	if report.Results[1].Status != "failed" || report.Results[1].Binding != "top-3" {
+		t.Errorf("Expected second result to be failed binding top-3, got %+v", report.Results[1])
+	}
+	if report.Results[0].Duration != 12 {
+		t.Errorf("Expected duration of 12ms, got %v", report.Results[0].Duration)
+	}
+}
+
+func TestReporterBailOut(t *testing.T) {
+	expected := map[string]string{
+		"tap":   "Bail out! Failed to run script 'setup.sql'\n",
+		"junit": `<error message="Bail out!">Failed to run script &#39;setup.sql&#39;</error>`,
+		"json":  `"bail_out": "Failed to run script 'setup.sql'"`,
+	}
+	for _, format := range ReportFormats {
+		var b bytes.Buffer
+		r, err := NewReporter(format, &b)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		r.Header()
+		r.BailOut("Failed to run script 'setup.sql'")
+		if err := r.Finish(); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		if !strings.Contains(b.String(), expected[format]) {
+			t.Errorf("%s: expected %q in the report, got:\n%s", format, expected[format], b.String())
+		}
+	}
+}
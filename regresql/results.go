@@ -0,0 +1,131 @@
+package regresql
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// A resultPair associates the actual output of a query binding, as written
+// in the out directory by `regresql test`, with its expected output.
+type resultPair struct {
+	Name     string // e.g. src/sql/artist.1.out
+	Expected string // the expected file the actual output is compared to
+	Actual   string
+	Accept   string  // the expected file to write when accepting the output
+	Options  Options // the comparison options, see compareFiles
+}
+
+/*
+resultPairs returns the out and expected files of every query binding in
+the Suite, in order, for each of the targets. Queries are parsed to find
+their bindings, but nothing is run, so that no database connection is
+needed. The out files of named targets are found in their own directory,
+and the pairs are named after the target then, as the tests are.
+
+When the Explain option is set, the plan files of the query bindings are
+paired too.
+
+The results of each target are compared to the version-specific expected
+files (e.g. query.pg16.out) of its PostgreSQL major version, as recorded by
+Test(), when they exist, as in CompareResultSets. When pgMajor > 0, it is
+used for every target instead, and accepting a result writes the expected
+file of that version, creating it when needed. So do the results of
+several targets, each one into the expected files of its own version, so
+that they don't overwrite each other's.
+*/
+func (s *Suite) resultPairs(config config, targets []target, pgMajor int) ([]resultPair, error) {
+	var pairs []resultPair
+
+	for _, t := range targets {
+		major := pgMajor
+		if major == 0 {
+			major = readPgVersion(t.outDir(s))
+		}
+		versioned := pgMajor > 0 || len(targets) > 1
+
+		for _, job := range s.jobs() {
+			plans, err := s.getPlans(job.folder, job.name, config)
+			if err != nil {
+				return nil, err
+			}
+
+			odir := filepath.Join(t.outDir(s), job.folder.Dir)
+			edir := filepath.Join(s.ExpectedDir, job.folder.Dir)
+
+			for _, p := range plans {
+				pairs = append(pairs, p.resultPairs(filepath.Join(t.Name, job.folder.Dir), odir, edir, major, versioned)...)
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// resultPairs returns the out and expected files of every binding of the
+// Plan, named after their directory dir, see Suite.resultPairs. Accepting a
+// result writes the expected file of the pgMajor version when versioned is
+// true, and the expected file it is compared to otherwise.
+func (p *Plan) resultPairs(dir, odir, edir string, pgMajor int, versioned bool) []resultPair {
+	var pairs []resultPair
+
+	pair := func(actual string, o Options) resultPair {
+		base := filepath.Base(actual)
+		pair := resultPair{
+			Name:     filepath.Join(dir, base),
+			Expected: expectedFile(edir, base, pgMajor),
+			Actual:   actual,
+			Options:  o,
+		}
+		pair.Accept = pair.Expected
+		if versioned {
+			pair.Accept = versionedFile(edir, base, pgMajor)
+		}
+		return pair
+	}
+
+	n := len(p.Names)
+	if len(p.Query.Params) == 0 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
+		actual := getResultSetPath(p, odir, i, 0)
+		pairs = append(pairs, pair(actual, p.Options))
+
+		if p.Options.Explain {
+			pairs = append(pairs, pair(explainPath(actual), Options{}))
+		}
+	}
+	return pairs
+}
+
+// changed returns true when the actual output of the pair differs from the
+// expected one, as compared by CompareResultSets: following the pair
+// Options, such as the Tolerance rules, rather than line by line. A missing
+// expected file counts as a change.
+func (pair resultPair) changed() (bool, error) {
+	if _, err := os.Stat(pair.Expected); os.IsNotExist(err) {
+		return true, nil
+	}
+	diff, err := compareFiles(pair.Expected, pair.Actual, pair.Options)
+	if err != nil {
+		return false, err
+	}
+	return diff != "", nil
+}
+
+// readLines returns the lines of the expected and actual files of the pair.
+// A missing expected file reads as empty, and ok is false when the actual
+// file is missing, which means the query has not been tested yet.
+func (pair resultPair) readLines() (expected []string, actual []string, ok bool, err error) {
+	if _, err := os.Stat(pair.Actual); os.IsNotExist(err) {
+		return nil, nil, false, nil
+	}
+	if actual, err = readLines(pair.Actual); err != nil {
+		return nil, nil, false, err
+	}
+	if _, err := os.Stat(pair.Expected); err == nil {
+		if expected, err = readLines(pair.Expected); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	return expected, actual, true, nil
+}
@@ -2,6 +2,7 @@ package regresql
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -14,12 +15,24 @@ import (
 
 /*
 A ResultSet stores the result of a Query in Filename, with Cols and Rows
-separated.
+separated. Types are the PostgreSQL type names of the columns, in lower
+case, and empty when unknown. Duration is the time it took to run the query.
+Explain is the normalized query plan, when the Explain option is set.
+Failure tells why the outcome of the query isn't the expected one, when it
+is expected to fail, see ExpectedError. Error is set instead of the result
+when the query failed to run, see formatQueryError, and TimedOut when it
+has been canceled after the Timeout option.
 */
 type ResultSet struct {
 	Cols     []string
+	Types    []string
 	Rows     [][]interface{}
 	Filename string
+	Duration time.Duration
+	Explain  string
+	Failure  string
+	Error    string
+	TimedOut bool
 }
 
 // GetPgMajorVersion returns the PostgreSQL server's major version number
@@ -36,7 +49,7 @@ func GetPgMajorVersion(db *sql.DB) (int, error) {
 // query (select 1"), because some errors (such as missing SSL certificates)
 // only happen at query time.
 func TestConnectionString(pguri string) error {
-	fmt.Printf("Connecting to '%s'… ", pguri)
+	fmt.Printf("Connecting to '%s'… ", redactPgUri(pguri))
 	db, err := sql.Open("postgres", pguri)
 
 	if err != nil {
@@ -55,14 +68,53 @@ func TestConnectionString(pguri string) error {
 	return nil
 }
 
+// queryer is implemented by *sql.DB, *sql.Conn and *sql.Tx, so that queries
+// can run either directly, on a dedicated connection, or within a
+// transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// beginner is implemented by both *sql.DB and *sql.Conn, so that
+// transactions can start on either of them.
+type beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
 // QueryDB runs the query against the db database connection, and returns a
 // ResultSet
 func QueryDB(db *sql.DB, query string, args ...interface{}) (*ResultSet, error) {
 	if db == nil {
 		return nil, errors.New("db is nil")
 	}
+	return queryResultSet(context.Background(), db, query, args...)
+}
+
+// QueryTx runs the query in a new transaction on the db database
+// connection, and always rolls the transaction back, so that queries
+// modifying the database leave it unchanged.
+func QueryTx(db *sql.DB, query string, args ...interface{}) (*ResultSet, error) {
+	if db == nil {
+		return nil, errors.New("db is nil")
+	}
+	return queryTx(context.Background(), db, query, args...)
+}
+
+// queryTx runs the query in a new transaction started with b, and always
+// rolls the transaction back.
+func queryTx(ctx context.Context, b beginner, query string, args ...interface{}) (*ResultSet, error) {
+	tx, err := b.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return queryResultSet(ctx, tx, query, args...)
+}
 
-	rows, err := db.Query(query, args...)
+// queryResultSet runs the query with q and returns a ResultSet
+func queryResultSet(ctx context.Context, q queryer, query string, args ...interface{}) (*ResultSet, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +125,15 @@ func QueryDB(db *sql.DB, query string, args ...interface{}) (*ResultSet, error)
 		return nil, err
 	}
 
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, len(colTypes))
+	for i, ct := range colTypes {
+		types[i] = strings.ToLower(ct.DatabaseTypeName())
+	}
+
 	res := make([][]interface{}, 0)
 
 	for rows.Next() {
@@ -90,7 +151,10 @@ func QueryDB(db *sql.DB, query string, args ...interface{}) (*ResultSet, error)
 
 		res = append(res, r)
 	}
-	return &ResultSet{cols, res, ""}, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &ResultSet{Cols: cols, Types: types, Rows: res}, nil
 }
 
 // Println outputs to standard output a Pretty Printed result set.
@@ -99,7 +163,19 @@ func (r *ResultSet) Println() {
 
 }
 
-// PrettyPrint pretty prints a result set and returns it as a string
+/*
+PrettyPrint pretty prints a result set and returns it as a string. Values
+spanning several lines are printed as psql does: each line of the value on
+its own line of output, and a "+" marker after the lines that continue, in
+place of the space before the column separator, or after the padded value
+in the last column:
+
+	 id | body
+	----+------
+	1   | one +
+	    | two
+	2   | three
+*/
 func (r *ResultSet) PrettyPrint() string {
 	var b bytes.Buffer
 
@@ -113,9 +189,10 @@ func (r *ResultSet) PrettyPrint() string {
 	}
 	for _, row := range r.Rows {
 		for i, value := range row {
-			l := len(valueToString(value))
-			if l > maxl[i] {
-				maxl[i] = l
+			for _, line := range strings.Split(valueToString(value), "\n") {
+				if l := len(line); l > maxl[i] {
+					maxl[i] = l
+				}
 			}
 		}
 	}
@@ -143,25 +220,153 @@ func (r *ResultSet) PrettyPrint() string {
 	fmt.Fprintf(&b, "\n")
 
 	for _, row := range r.Rows {
+		cells := make([][]string, len(row))
+		height := 1
 		for i, value := range row {
-			s := valueToString(value)
-			if i+1 < cn {
-				fmt.Fprintf(&b, fmts[i], s)
-				fmt.Fprintf(&b, " | ")
-			} else {
-				fmt.Fprintf(&b, s)
+			cells[i] = strings.Split(valueToString(value), "\n")
+			if len(cells[i]) > height {
+				height = len(cells[i])
 			}
 		}
-		fmt.Fprintf(&b, "\n")
+
+		for k := 0; k < height; k++ {
+			for i, lines := range cells {
+				s := ""
+				if k < len(lines) {
+					s = lines[k]
+				}
+				more := k+1 < len(lines)
+
+				switch {
+				case i+1 < cn && more:
+					fmt.Fprintf(&b, fmts[i], s)
+					fmt.Fprintf(&b, "+| ")
+				case i+1 < cn:
+					fmt.Fprintf(&b, fmts[i], s)
+					fmt.Fprintf(&b, " | ")
+				case more:
+					fmt.Fprintf(&b, fmts[i], s)
+					fmt.Fprintf(&b, "+")
+				default:
+					fmt.Fprintf(&b, s)
+				}
+			}
+			fmt.Fprintf(&b, "\n")
+		}
 	}
 	return b.String()
 }
 
+/*
+parsePretty reads back the columns names and the rows of a result set
+pretty printed by PrettyPrint, given as lines. The cells are cut at the
+columns widths given by the separator line, rather than split on the column
+separator, so that values containing " | " or several lines are read as
+they were printed. The trailing spaces of the values are not kept.
+*/
+func parsePretty(lines []string) (table, error) {
+	var t table
+
+	lines = append([]string{}, lines...)
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\n")
+	}
+	// difflib.SplitLines adds an empty last line after the final newline
+	if n := len(lines); n > 2 && lines[n-1] == "" {
+		lines = lines[:n-1]
+	}
+	if len(lines) < 2 || strings.Trim(lines[1], "-+") != "" {
+		return t, fmt.Errorf("missing header")
+	}
+
+	var widths []int
+	for _, dashes := range strings.Split(lines[1], "-+-") {
+		widths = append(widths, len(dashes))
+	}
+
+	header, _, ok := splitPretty(lines[0], widths)
+	if !ok {
+		return t, fmt.Errorf("invalid header %q", lines[0])
+	}
+	for _, name := range header {
+		t.Columns = append(t.Columns, column{Name: strings.TrimSpace(name)})
+	}
+
+	var more []bool
+	continued := false
+	for _, line := range lines[2:] {
+		cells, next, ok := splitPretty(line, widths)
+		if !ok {
+			return t, fmt.Errorf("invalid row %q", line)
+		}
+		if continued {
+			row := t.Rows[len(t.Rows)-1]
+			for i := range row {
+				if more[i] {
+					*row[i] += "\n" + cells[i]
+				}
+			}
+		} else {
+			row := make([]*string, len(cells))
+			for i := range cells {
+				row[i] = &cells[i]
+			}
+			t.Rows = append(t.Rows, row)
+		}
+
+		more, continued = next, false
+		for _, m := range more {
+			continued = continued || m
+		}
+	}
+	return t, nil
+}
+
+// splitPretty cuts a pretty printed line at the columns widths, and returns
+// its cells and whether they continue on the next line. ok is false when
+// the line doesn't match the widths.
+func splitPretty(line string, widths []int) (cells []string, more []bool, ok bool) {
+	runes := []rune(line)
+	cells = make([]string, len(widths))
+	more = make([]bool, len(widths))
+
+	pos := 0
+	for i, w := range widths {
+		if i+1 == len(widths) {
+			rest := runes[pos:]
+			if len(rest) == w+1 && rest[w] == '+' {
+				rest, more[i] = rest[:w], true
+			}
+			cells[i] = strings.TrimRight(string(rest), " ")
+			break
+		}
+		if pos+w+3 > len(runes) {
+			return nil, nil, false
+		}
+		switch string(runes[pos+w : pos+w+3]) {
+		case " | ":
+		case "+| ":
+			more[i] = true
+		default:
+			return nil, nil, false
+		}
+		cells[i] = strings.TrimRight(string(runes[pos:pos+w]), " ")
+		pos += w + 3
+	}
+	return cells, more, true
+}
+
 // Writes the Result Set r to filename, overwriting it if already exists
-// when overwrite is true
+// when overwrite is true. The format depends on the filename extension: .json
+// and .csv files are structured, other files are Pretty Printed.
 func (r *ResultSet) Write(filename string, overwrite bool) error {
 	var f *os.File
 	var err error
+
+	contents, err := r.contents(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to format result set '%s': %s\n", filename, err)
+	}
 	if _, err = os.Stat(filename); os.IsNotExist(err) {
 		f, err = os.Create(filename)
 
@@ -174,7 +379,7 @@ func (r *ResultSet) Write(filename string, overwrite bool) error {
 		}
 		defer f.Close()
 
-		fmt.Fprint(f, r.PrettyPrint())
+		fmt.Fprint(f, contents)
 	} else {
 		if !overwrite {
 			return errors.New("Target file '%s' already exists")
@@ -200,7 +405,7 @@ func (r *ResultSet) Write(filename string, overwrite bool) error {
 		}
 		defer f.Close()
 
-		fmt.Fprint(f, r.PrettyPrint())
+		fmt.Fprint(f, contents)
 	}
 	return nil
 }
@@ -0,0 +1,174 @@
+package regresql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+/*
+A Runner runs the regression tests of a code repository from Go code, such
+as a `go test` function, against a connection pool opened by the caller:
+
+	func TestQueries(t *testing.T) {
+		db := openTestDB(t)
+		regresql.NewRunner("..", db).Test(t)
+	}
+
+The regresql/ directory of Root is used as with the regresql command: the
+configuration file, the plans and the expected files are read from there,
+and the actual result files are written to regresql/out. The pguri and the
+targets of the configuration file are not used, DB is. Unlike the Test and
+Update functions, a Runner doesn't print to standard output nor exits, the
+outcome of each test and the errors are returned.
+*/
+type Runner struct {
+	Root     string        // code repository root, where regresql/ is
+	DB       *sql.DB       // database to run the queries against
+	Filter   Filter        // tests to run, all of them by default
+	Jobs     int           // how many query files to run concurrently
+	Timeout  time.Duration // query timeout overriding the timeout setting, when > 0
+	Reporter Reporter      // also reports the test results to it, when set
+	Log      io.Writer     // progress messages, discarded when nil
+}
+
+// NewRunner returns a Runner for the code repository found in root, running
+// the queries one file at a time against db.
+func NewRunner(root string, db *sql.DB) *Runner {
+	return &Runner{Root: root, DB: db, Jobs: 1}
+}
+
+// suite returns the Suite of the Runner, with the tests selected by its
+// Filter, and the configuration to run it with.
+func (r *Runner) suite() (*Suite, config, error) {
+	if r.DB == nil {
+		return nil, config{}, fmt.Errorf("No database to run the tests against\n")
+	}
+
+	config, err := newSuite(r.Root).readConfig()
+	if err != nil {
+		return nil, config, err
+	}
+	if r.Timeout > 0 {
+		config.Timeout = r.Timeout
+	}
+
+	s := WalkFrom(r.Root, resolveRoot(r.Root, config.Root), config.Exclude)
+	s.out = r.Log
+	if s.out == nil {
+		s.out = ioutil.Discard
+	}
+	if err := s.filter(config, r.Filter); err != nil {
+		return nil, config, err
+	}
+	return s, config, nil
+}
+
+/*
+Run runs the selected queries and compares their results to the expected
+files, and returns the result of every test, in the Suite order.
+
+The error is an *ErrTestsFailed when tests failed or errored, an
+*ErrBailOut when a setup or teardown script failed or when ctx is done, in
+which case the results are the ones of the tests run until then, or another
+error when the Suite can't run at all.
+*/
+func (r *Runner) Run(ctx context.Context) ([]TestResult, error) {
+	s, config, err := r.suite()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &resultsReporter{next: r.Reporter}
+	err = s.runTests(ctx, config, []target{{db: r.DB}}, RunOptions{Jobs: r.Jobs}, c)
+	return c.results, err
+}
+
+// Update runs the selected queries and writes their results to the expected
+// files, see the Update function.
+func (r *Runner) Update(ctx context.Context) error {
+	s, config, err := r.suite()
+	if err != nil {
+		return err
+	}
+	return s.createExpectedResults(ctx, config, target{db: r.DB}, RunOptions{Jobs: r.Jobs}, nil)
+}
+
+/*
+Test runs the tests with Run, and reports each of them as a subtest of t,
+named after the test, e.g. src/sql/artist.1.out. The subtests fail with the
+diff or the error of the failed tests, and t fails when the Suite can't
+run. The tests are canceled at the deadline of t, if any.
+*/
+func (r *Runner) Test(t *testing.T) {
+	t.Helper()
+
+	ctx := context.Background()
+	if deadline, ok := t.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	results, err := r.Run(ctx)
+	for _, result := range results {
+		result := result
+		t.Run(result.Name, func(t *testing.T) {
+			switch {
+			case result.Errored:
+				t.Errorf("Failed to run test:\n%s", result.Error)
+			case result.TimedOut:
+				t.Errorf("Timeout:\n%s", result.Error)
+			case !result.Passed && result.Diff != "":
+				t.Errorf("Result differs from '%s':\n%s", result.ExpectedFile, result.Diff)
+			case !result.Passed && result.Error != "":
+				t.Errorf("Unexpected query outcome: %s", result.Error)
+			case !result.Passed:
+				t.Errorf("Performance regression: %s", result.Slow)
+			case result.Slow != "":
+				t.Logf("Performance regression: %s", result.Slow)
+			}
+		})
+	}
+
+	if _, failed := err.(*ErrTestsFailed); err != nil && !failed {
+		t.Fatal(err)
+	}
+}
+
+// resultsReporter collects the test results, and reports them to next too
+// when set.
+type resultsReporter struct {
+	next    Reporter
+	results []TestResult
+}
+
+func (r *resultsReporter) Header() {
+	if r.next != nil {
+		r.next.Header()
+	}
+}
+
+func (r *resultsReporter) Report(result TestResult) {
+	r.results = append(r.results, result)
+	if r.next != nil {
+		r.next.Report(result)
+	}
+}
+
+func (r *resultsReporter) BailOut(reason string) {
+	if r.next != nil {
+		r.next.BailOut(reason)
+	}
+}
+
+func (r *resultsReporter) Finish() error {
+	if r.next != nil {
+		return r.next.Finish()
+	}
+	return nil
+}
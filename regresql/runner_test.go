@@ -0,0 +1,84 @@
+package regresql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestRunner returns a Runner for a code repository with a query file,
+// connected to a database that refuses connections.
+func newTestRunner(t *testing.T) *Runner {
+	t.Helper()
+	s := writeConfig(t, "pguri: postgres:///db\n")
+	if err := os.MkdirAll(filepath.Join(s.Root, "src", "sql"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	query := filepath.Join(s.Root, "src", "sql", "version.sql")
+	if err := ioutil.WriteFile(query, []byte("SELECT version();\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("postgres", "postgres://localhost:1/db?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewRunner(s.Root, db)
+}
+
+func TestRunnerRun(t *testing.T) {
+	r := newTestRunner(t)
+	var log, tap bytes.Buffer
+	r.Log = &log
+	r.Reporter, _ = NewReporter("tap", &tap)
+
+	results, err := r.Run(context.Background())
+	e, ok := err.(*ErrTestsFailed)
+	if !ok || e.Errors != 1 {
+		t.Fatalf("Expected 1 errored test, got %v", err)
+	}
+	if len(results) != 1 || results[0].Name != "src/sql/version.out" || !results[0].Errored {
+		t.Errorf("Expected the errored test in the results, got %+v", results)
+	}
+	if !strings.Contains(log.String(), "Creating directory") {
+		t.Errorf("Expected progress messages in the log, got %q", log.String())
+	}
+	if !strings.Contains(tap.String(), "not ok 1 - src/sql/version.out") {
+		t.Errorf("Expected the test in the TAP report, got:\n%s", tap.String())
+	}
+}
+
+func TestRunnerUpdate(t *testing.T) {
+	r := newTestRunner(t)
+
+	err := r.Update(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Failed to update 1 expected result(s)") {
+		t.Errorf("Expected the binding failing to run to fail the update, got %v", err)
+	}
+}
+
+func TestRunnerErrors(t *testing.T) {
+	r := newTestRunner(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.Run(ctx); err == nil || err.Error() != "Bail out! Interrupted\n" {
+		t.Errorf("Expected the run to be interrupted, got %v", err)
+	}
+
+	r.Filter = Filter{Paths: []string{"src/sql/missing.sql"}}
+	if _, err := r.Run(context.Background()); err == nil {
+		t.Error("Expected an error for a filter matching no query file, got nil")
+	}
+
+	r.DB = nil
+	if err := r.Update(context.Background()); err == nil {
+		t.Error("Expected an error without a database, got nil")
+	}
+}
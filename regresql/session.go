@@ -0,0 +1,88 @@
+package regresql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/*
+parseSettings returns a copy of settings changed with directive arguments
+given as name=value pairs, the session settings (GUCs) to set before
+running a query:
+
+	-- regresql: set TimeZone=UTC DateStyle='ISO, YMD'
+*/
+func parseSettings(settings map[string]string, args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return settings, fmt.Errorf("set expects name=value arguments")
+	}
+
+	// don't change the defaults map
+	changed := make(map[string]string, len(settings)+len(args))
+	for name, value := range settings {
+		changed[name] = value
+	}
+	for _, arg := range args {
+		kv, err := splitKeyValue("set", arg)
+		if err != nil {
+			return settings, err
+		}
+		changed[kv[0]] = kv[1]
+	}
+	return changed, nil
+}
+
+// setSession applies the Settings, Role and Timeout options to the session
+// of conn, see resetSession. The Timeout is set as the statement_timeout.
+func (o Options) setSession(ctx context.Context, conn *sql.Conn) error {
+	var names []string
+	for name := range o.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := o.Settings[name]
+		if _, err := conn.ExecContext(ctx, "SELECT set_config($1, $2, false)", name, value); err != nil {
+			return fmt.Errorf("Failed to set %s to '%s': %s", name, value, err)
+		}
+	}
+
+	if o.Role != "" {
+		if _, err := conn.ExecContext(ctx, "SET ROLE "+pq.QuoteIdentifier(o.Role)); err != nil {
+			return fmt.Errorf("Failed to set role '%s': %s", o.Role, err)
+		}
+	}
+
+	if o.Timeout > 0 {
+		// statement_timeout is in milliseconds, where 0 disables it
+		ms := strconv.FormatInt(o.Timeout.Milliseconds(), 10)
+		if o.Timeout < time.Millisecond {
+			ms = "1"
+		}
+		if _, err := conn.ExecContext(ctx, "SELECT set_config('statement_timeout', $1, false)", ms); err != nil {
+			return fmt.Errorf("Failed to set statement_timeout to %s: %s", o.Timeout, err)
+		}
+	}
+	return nil
+}
+
+// resetSession resets the session of conn after setSession, so that the
+// connection goes back to the pool in its initial state. When that fails,
+// the connection is discarded rather than reused. RESET ALL doesn't reset
+// the role.
+func (o Options) resetSession(ctx context.Context, conn *sql.Conn) {
+	if len(o.Settings) == 0 && o.Role == "" && o.Timeout <= 0 {
+		return
+	}
+	if _, err := conn.ExecContext(ctx, "RESET ROLE; RESET ALL"); err != nil {
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+}
@@ -0,0 +1,61 @@
+package regresql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSessionOptions(t *testing.T) {
+	s := writeConfig(t, `pguri: postgres:///db
+role: app_user
+settings:
+  TimeZone: UTC
+  extra_float_digits: 0
+`)
+	config, err := s.readConfig()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	defaults := map[string]string{"timezone": "UTC", "extra_float_digits": "0"}
+	if config.Role != "app_user" || !reflect.DeepEqual(config.Settings, defaults) {
+		t.Fatalf("Expected role and settings from regress.yaml, got %q %v", config.Role, config.Settings)
+	}
+
+	q := mustParseQueryString(t, "src/sql/q.sql",
+		"-- regresql: set DateStyle='ISO, YMD' TimeZone=Europe/Paris\n-- regresql: role admin\nSELECT 1;\n")
+	directives, err := parsePlanDirectives([]byte("regresql:\n  set:\n    search_path: app, public\n  role: auditor\n"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	p := &Plan{Query: q, Directives: directives}
+	if err := p.SetOptions(config.Options); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	expected := map[string]string{
+		"timezone":           "UTC",
+		"TimeZone":           "Europe/Paris",
+		"DateStyle":          "ISO, YMD",
+		"extra_float_digits": "0",
+		"search_path":        "app, public",
+	}
+	if p.Options.Role != "auditor" || !reflect.DeepEqual(p.Options.Settings, expected) {
+		t.Errorf("Expected role auditor and settings %v, got %q %v", expected, p.Options.Role, p.Options.Settings)
+	}
+	if !reflect.DeepEqual(config.Settings, defaults) {
+		t.Errorf("Expected the defaults to be left unchanged, got %v", config.Settings)
+	}
+
+	q = mustParseQueryString(t, "src/sql/q.sql", "-- regresql: set off\n-- regresql: role off\nSELECT 1;\n")
+	opts, err := q.resolveOptions(config.Options)
+	if err != nil || opts.Settings != nil || opts.Role != "" {
+		t.Errorf("Expected set off and role off to reset the options, got %q %v (%v)", opts.Role, opts.Settings, err)
+	}
+
+	for _, header := range []string{"set", "set TimeZone", "role", "role a b"} {
+		q = mustParseQueryString(t, "src/sql/q.sql", "-- regresql: "+header+"\nSELECT 1;\n")
+		if _, err := q.resolveOptions(Options{}); err == nil {
+			t.Errorf("Expected an error for %q, got nil", header)
+		}
+	}
+}
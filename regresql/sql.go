@@ -15,6 +15,13 @@ var setLineRE = regexp.MustCompile(`(?m)^[ \t]*\\set[ \t]+([A-Za-z_][A-Za-z0-9_]
 // value tokens (group 1).
 var bindLineRE = regexp.MustCompile(`(?m)^[ \t]*\\bind[ \t]*([^\r\n]*)\r?\n?`)
 
+// nameLineRE matches a `-- name:` comment line, capturing the query name
+// (group 1).
+var nameLineRE = regexp.MustCompile(`(?m)^[ \t]*--[ \t]*name:[ \t]*([^\s:]+)[^\r\n]*$`)
+
+// commentRE matches SQL comments, block comments being not nested.
+var commentRE = regexp.MustCompile(`(?s)--[^\r\n]*|/\*.*?\*/`)
+
 /*
 
 A Query represents an SQL query read from Path. Text holds the original source,
@@ -38,6 +45,7 @@ Positional mode ($N):
 */
 type Query struct {
 	Path         string
+	Name         string            // `-- name:` of the query, when the file holds several
 	Text         string            // original query text (including \set / \bind lines)
 	Query        string            // normalised SQL for lib/pq
 	Vars         []string          // unique variable names
@@ -45,6 +53,7 @@ type Query struct {
 	Defaults     map[string]string // defaults from \set (named mode)
 	BindDefaults []string          // defaults from \bind (positional mode, 0-indexed: [0]=val for $1)
 	Positional   bool              // true when using $N style
+	Directives   []Directive       // `-- regresql:` header comments
 }
 
 // ── \set support (unchanged) ─────────────────────────────────────────────────
@@ -651,13 +660,80 @@ func scanAndReplaceNamedParams(text string) (normSQL string, vars []string, para
 
 // ── Query parsing ─────────────────────────────────────────────────────────────
 
-// parseQueryFile reads a SQL file and returns a Query instance.
-func parseQueryFile(queryPath string) (*Query, error) {
+// parseQueriesFile reads a SQL file and returns its Query instances, see
+// parseQueries.
+func parseQueriesFile(queryPath string) ([]*Query, error) {
 	sqlbytes, err := ioutil.ReadFile(queryPath)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse query file '%s': %s\n", queryPath, err)
 	}
-	return parseQueryString(queryPath, string(sqlbytes))
+	return parseQueries(queryPath, string(sqlbytes))
+}
+
+/*
+parseQueries splits a SQL string (previously read from queryPath) on its
+`-- name:` markers, as found in yesql, sqlc or PugSQL query libraries, and
+returns a Query per marker, in order:
+
+	-- name: list-artists
+	select name from artist;
+
+	-- name: list-albums-by-artist :many
+	select title from album where artist = :artist;
+
+Anything following the name on the marker line is ignored. The directives
+and \set defaults found before the first marker apply to every query of the
+file, the defaults of a query winning, and any other SQL found there is an
+error. A file with less than two markers holds a single Query, which has no
+Name.
+*/
+func parseQueries(queryPath string, text string) ([]*Query, error) {
+	markers := nameLineRE.FindAllStringSubmatchIndex(text, -1)
+
+	if len(markers) < 2 {
+		q, err := parseQueryString(queryPath, text)
+		if err != nil {
+			return nil, err
+		}
+		return []*Query{q}, nil
+	}
+
+	header := extractDirectives(text[:markers[0][0]])
+	rest, defaults := extractSetCommands(text[:markers[0][0]])
+	if strings.TrimSpace(commentRE.ReplaceAllString(rest, "")) != "" {
+		return nil, fmt.Errorf("SQL found before the first query name in '%s'\n", queryPath)
+	}
+	seen := make(map[string]bool)
+
+	var queries []*Query
+	for i, m := range markers {
+		name := text[m[2]:m[3]]
+		if name == planOptionsKey || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("Invalid query name %q in '%s'\n", name, queryPath)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("Duplicate query name %q in '%s'\n", name, queryPath)
+		}
+		seen[name] = true
+
+		end := len(text)
+		if i+1 < len(markers) {
+			end = markers[i+1][0]
+		}
+		q, err := parseQueryString(queryPath, text[m[0]:end])
+		if err != nil {
+			return nil, err
+		}
+		q.Name = name
+		q.Directives = append(append([]Directive{}, header...), q.Directives...)
+		for name, value := range defaults {
+			if _, ok := q.Defaults[name]; !ok {
+				q.Defaults[name] = value
+			}
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
 }
 
 // parseQueryString parses a SQL string (previously read from queryPath) and
@@ -676,6 +752,9 @@ func parseQueryFile(queryPath string) (*Query, error) {
 //	->  Query = "SELECT $1::int + $2::int"  (unchanged)
 //	    Vars  = ["p1","p2"], Params = ["p1","p2"], Positional = true
 func parseQueryString(queryPath string, queryString string) (*Query, error) {
+	// Collect `-- regresql:` directives, kept in the SQL as comments.
+	directives := extractDirectives(queryString)
+
 	// Strip \set metacommands; collect named-param defaults.
 	afterSet, setDefaults := extractSetCommands(queryString)
 
@@ -725,6 +804,7 @@ func parseQueryString(queryPath string, queryString string) (*Query, error) {
 			Defaults:     setDefaults,
 			BindDefaults: bindDefaults,
 			Positional:   true,
+			Directives:   directives,
 		}, nil
 	}
 
@@ -732,12 +812,13 @@ func parseQueryString(queryPath string, queryString string) (*Query, error) {
 	// normSQL already has :varname replaced by $N; namedVars and namedParams
 	// are in first-appearance / occurrence order from scanAndReplaceNamedParams.
 	return &Query{
-		Path:     queryPath,
-		Text:     queryString,
-		Query:    normSQL,
-		Vars:     namedVars,
-		Params:   namedParams,
-		Defaults: setDefaults,
+		Path:       queryPath,
+		Text:       queryString,
+		Query:      normSQL,
+		Vars:       namedVars,
+		Params:     namedParams,
+		Defaults:   setDefaults,
+		Directives: directives,
 	}, nil
 }
 
@@ -751,12 +832,19 @@ func parseQueryString(queryPath string, queryString string) (*Query, error) {
 //  2. q.BindDefaults[i]   — \bind default  (positional mode, index i)
 //     q.Defaults[varname] — \set default   (named mode)
 //  3. error               — parameter unresolvable
-func (q *Query) Prepare(bindings map[string]string) (string, []interface{}, error) {
+//
+// Plan values are converted to driver values: nil binds NULL, sequences bind
+// arrays, and TypedParam values are converted according to their type.
+func (q *Query) Prepare(bindings map[string]interface{}) (string, []interface{}, error) {
 	params := make([]interface{}, len(q.Params))
 
 	for i, varname := range q.Params {
 		if val, ok := bindings[varname]; ok {
-			params[i] = val
+			v, err := paramValue(val)
+			if err != nil {
+				return "", nil, fmt.Errorf("parameter %q: %s", varname, err)
+			}
+			params[i] = v
 		} else if q.Positional && i < len(q.BindDefaults) {
 			params[i] = q.BindDefaults[i]
 		} else if val, ok := q.Defaults[varname]; ok {
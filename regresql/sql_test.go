@@ -1,6 +1,7 @@
 package regresql
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -40,7 +41,7 @@ func TestParseQueryStringWithTypeCast(t *testing.T) {
 func TestPrepareOneParam(t *testing.T) {
 	queryString := `select * from foo where id = :id`
 	q := mustParseQueryString(t, "no/path", queryString)
-	b := map[string]string{"id": "1"}
+	b := map[string]interface{}{"id": "1"}
 
 	sql, params, err := q.Prepare(b)
 
@@ -58,7 +59,7 @@ func TestPrepareOneParam(t *testing.T) {
 func TestPrepareTwoParams(t *testing.T) {
 	queryString := `select * from foo where a = :a and b between :a and :b`
 	q := mustParseQueryString(t, "no/path", queryString)
-	b := map[string]string{"a": "a", "b": "b"}
+	b := map[string]interface{}{"a": "a", "b": "b"}
 
 	sql, params, err := q.Prepare(b)
 
@@ -136,7 +137,7 @@ func TestSetUnusedVariable(t *testing.T) {
 
 func TestPrepareDefaultFallback(t *testing.T) {
 	q := mustParseQueryString(t, "no/path", "\\set n 10\nSELECT :n::int;\n")
-	_, params, err := q.Prepare(map[string]string{})
+	_, params, err := q.Prepare(map[string]interface{}{})
 	if err != nil {
 		t.Fatal("Unexpected error:", err)
 	}
@@ -147,7 +148,7 @@ func TestPrepareDefaultFallback(t *testing.T) {
 
 func TestPrepareMissingVar(t *testing.T) {
 	q := mustParseQueryString(t, "no/path", `SELECT :n::int;`)
-	_, _, err := q.Prepare(map[string]string{})
+	_, _, err := q.Prepare(map[string]interface{}{})
 	if err == nil {
 		t.Error("Expected error for missing variable, got nil")
 	}
@@ -155,7 +156,7 @@ func TestPrepareMissingVar(t *testing.T) {
 
 func TestPrepareBindingOverridesDefault(t *testing.T) {
 	q := mustParseQueryString(t, "no/path", "\\set n 10\nSELECT :n::int;\n")
-	_, params, err := q.Prepare(map[string]string{"n": "99"})
+	_, params, err := q.Prepare(map[string]interface{}{"n": "99"})
 	if err != nil {
 		t.Fatal("Unexpected error:", err)
 	}
@@ -295,7 +296,7 @@ func TestBindNoValues(t *testing.T) {
 
 func TestPositionalPrepareFromBinding(t *testing.T) {
 	q := mustParseQueryString(t, "no/path", "SELECT $1::int + $2::int AS sum;\n")
-	_, params, err := q.Prepare(map[string]string{"p1": "3", "p2": "4"})
+	_, params, err := q.Prepare(map[string]interface{}{"p1": "3", "p2": "4"})
 	if err != nil {
 		t.Fatal("Unexpected error:", err)
 	}
@@ -306,7 +307,7 @@ func TestPositionalPrepareFromBinding(t *testing.T) {
 
 func TestPositionalPrepareFromBindDefault(t *testing.T) {
 	q := mustParseQueryString(t, "no/path", "\\bind 3 4\nSELECT $1::int + $2::int AS sum;\n")
-	_, params, err := q.Prepare(map[string]string{})
+	_, params, err := q.Prepare(map[string]interface{}{})
 	if err != nil {
 		t.Fatal("Unexpected error:", err)
 	}
@@ -317,7 +318,7 @@ func TestPositionalPrepareFromBindDefault(t *testing.T) {
 
 func TestPositionalPrepareMissing(t *testing.T) {
 	q := mustParseQueryString(t, "no/path", "SELECT $1::int;\n")
-	_, _, err := q.Prepare(map[string]string{})
+	_, _, err := q.Prepare(map[string]interface{}{})
 	if err == nil {
 		t.Error("Expected error for missing positional parameter, got nil")
 	}
@@ -325,7 +326,7 @@ func TestPositionalPrepareMissing(t *testing.T) {
 
 func TestPositionalPrepareBindingOverridesDefault(t *testing.T) {
 	q := mustParseQueryString(t, "no/path", "\\bind 3\nSELECT $1::int;\n")
-	_, params, err := q.Prepare(map[string]string{"p1": "99"})
+	_, params, err := q.Prepare(map[string]interface{}{"p1": "99"})
 	if err != nil {
 		t.Fatal("Unexpected error:", err)
 	}
@@ -407,3 +408,119 @@ func TestNamedParamStringLiteralPreserved(t *testing.T) {
 		t.Errorf("Real :user_id should be replaced by $1 in q.Query, got: %s", q.Query)
 	}
 }
+
+// ── regresql directives tests ────────────────────────────────────────────────
+
+func TestDirectivesExtract(t *testing.T) {
+	q := mustParseQueryString(t, "no/path",
+		"-- name: insert-artist\n-- regresql: rollback\nINSERT INTO artist(name) VALUES (:name) RETURNING *;\n")
+
+	if len(q.Directives) != 1 || q.Directives[0].Name != "rollback" || len(q.Directives[0].Args) != 0 {
+		t.Fatalf("Expected a single bare \"rollback\" directive, got %v", q.Directives)
+	}
+	if len(q.Vars) != 1 || q.Vars[0] != "name" {
+		t.Errorf("Expected Vars==[\"name\"], got %v", q.Vars)
+	}
+}
+
+func TestDirectivesQuotedValues(t *testing.T) {
+	q := mustParseQueryString(t, "no/path",
+		"-- regresql: mask pattern='[0-9]{4} [0-9]{2}' replace=<n>\nSELECT 1;\n")
+
+	expected := []string{"pattern=[0-9]{4} [0-9]{2}", "replace=<n>"}
+	if len(q.Directives) != 1 || !reflect.DeepEqual(q.Directives[0].Args, expected) {
+		t.Fatalf("Expected a mask directive with args %q, got %v", expected, q.Directives)
+	}
+}
+
+func TestDirectivesOverrideDefaults(t *testing.T) {
+	q := mustParseQueryString(t, "no/path", "-- regresql: rollback off\nSELECT 1;\n")
+
+	opts, err := q.resolveOptions(Options{Rollback: true})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if opts.Rollback {
+		t.Error("Expected Rollback==false, directive should override the default")
+	}
+}
+
+func TestDirectivesUnknown(t *testing.T) {
+	q := mustParseQueryString(t, "no/path", "-- regresql: frobnicate\nSELECT 1;\n")
+
+	if _, err := q.resolveOptions(Options{}); err == nil {
+		t.Error("Expected error for unknown directive, got nil")
+	}
+}
+
+func TestParseQueriesNamed(t *testing.T) {
+	text := "-- regresql: unordered\n\n" +
+		"-- name: list-artists\nselect name from artist;\n\n" +
+		"-- name: list-albums-by-artist :many\n-- regresql: rollback\n" +
+		"select title from album where artist = :name;\n"
+
+	queries, err := parseQueries("src/sql/queries.sql", text)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("Expected 2 queries, got %d", len(queries))
+	}
+	if queries[0].Name != "list-artists" || len(queries[0].Vars) != 0 {
+		t.Errorf("Expected list-artists without variables, got %q %v", queries[0].Name, queries[0].Vars)
+	}
+	if queries[1].Name != "list-albums-by-artist" || !reflect.DeepEqual(queries[1].Vars, []string{"name"}) {
+		t.Errorf("Expected list-albums-by-artist with variable name, got %q %v", queries[1].Name, queries[1].Vars)
+	}
+	if strings.Contains(queries[0].Query, "album") || !strings.HasPrefix(queries[1].Text, "-- name: list-albums-by-artist") {
+		t.Errorf("Expected the file to be split on the markers, got %q and %q", queries[0].Query, queries[1].Text)
+	}
+
+	expected := []Directive{{"unordered", []string{}}, {"rollback", []string{}}}
+	if !reflect.DeepEqual(queries[1].Directives, expected) {
+		t.Errorf("Expected directives %v, got %v", expected, queries[1].Directives)
+	}
+	if len(queries[0].Directives) != 1 {
+		t.Errorf("Expected the header directive only, got %v", queries[0].Directives)
+	}
+}
+
+func TestParseQueriesSingle(t *testing.T) {
+	queries, err := parseQueries("src/sql/artist.sql", "-- name: list-artists\nselect name from artist;\n")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(queries) != 1 || queries[0].Name != "" {
+		t.Errorf("Expected a single unnamed query, got %d queries", len(queries))
+	}
+}
+
+func TestParseQueriesPreamble(t *testing.T) {
+	text := "/* artists queries */\n\\set name 'AC/DC'\n\\set limit 10\n\n" +
+		"-- name: list-albums\nselect title from album where artist = :name limit :limit;\n\n" +
+		"-- name: list-tracks\n\\set limit 5\nselect name from track limit :limit;\n"
+
+	queries, err := parseQueries("src/sql/queries.sql", text)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if d := queries[0].Defaults; d["name"] != "AC/DC" || d["limit"] != "10" {
+		t.Errorf("Expected the preamble defaults, got %v", d)
+	}
+	if d := queries[1].Defaults; d["limit"] != "5" {
+		t.Errorf("Expected the query's own limit default, got %v", d)
+	}
+
+	text = "create temp table t(id int);\n\n" +
+		"-- name: q1\nselect 1;\n-- name: q2\nselect 2;\n"
+	if _, err := parseQueries("src/sql/queries.sql", text); err == nil {
+		t.Error("Expected error for SQL before the first query name, got nil")
+	}
+}
+
+func TestParseQueriesDuplicateName(t *testing.T) {
+	text := "-- name: q\nselect 1;\n-- name: q\nselect 2;\n"
+	if _, err := parseQueries("src/sql/queries.sql", text); err == nil {
+		t.Error("Expected error for duplicate query names, got nil")
+	}
+}
@@ -0,0 +1,382 @@
+package regresql
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq/oid"
+)
+
+/*
+ResultFormats lists the supported expected and actual result file formats,
+set with the format option, the first one being the default:
+
+  - out is the pretty printed text format, comparable to psql output,
+    compared line by line,
+  - json and csv are structured formats that register the columns names
+    and PostgreSQL types, and are compared cell by cell.
+*/
+var ResultFormats = []string{"out", "json", "csv"}
+
+// checkResultFormat returns an error when format is not one of
+// ResultFormats. An empty format is the default one.
+func checkResultFormat(format string) error {
+	if format == "" || contains(ResultFormats, format) {
+		return nil
+	}
+	return fmt.Errorf("Unknown result format '%s', expected one of %v",
+		format, ResultFormats)
+}
+
+// resultExt returns the result files extension for the format option.
+func (o Options) resultExt() string {
+	if o.Format == "" {
+		return ".out"
+	}
+	return "." + o.Format
+}
+
+// csvNull is how NULL values are written in CSV result files, as in the
+// PostgreSQL COPY text format. Text values made of backslashes followed by
+// N are written with one more backslash, so that a \N value reads back as
+// text, see csvField and csvCell.
+const csvNull = `\N`
+
+// csvNullRE matches csvNull and the text values written with one more
+// backslash so that they are not mistaken for it.
+var csvNullRE = regexp.MustCompile(`^\\+N$`)
+
+// csvField returns the CSV field of a cell.
+func csvField(cell *string) string {
+	switch {
+	case cell == nil:
+		return csvNull
+	case csvNullRE.MatchString(*cell):
+		return `\` + *cell
+	}
+	return *cell
+}
+
+// csvCell returns the cell of a CSV field, nil for NULL.
+func csvCell(field string) *string {
+	switch {
+	case field == csvNull:
+		return nil
+	case csvNullRE.MatchString(field):
+		field = field[1:]
+	}
+	return &field
+}
+
+// typeOIDs maps PostgreSQL type names to their OID, for the types known to
+// the lib/pq driver.
+var typeOIDs = func() map[string]oid.Oid {
+	oids := make(map[string]oid.Oid, len(oid.TypeName))
+	for o, name := range oid.TypeName {
+		oids[strings.ToLower(name)] = o
+	}
+	return oids
+}()
+
+// A column is the name and PostgreSQL type of a column in a structured
+// result file. The type OID is 0 when unknown.
+type column struct {
+	Name string  `json:"name"`
+	Type string  `json:"type"`
+	OID  oid.Oid `json:"oid"`
+}
+
+// A table is the contents of a structured result file, where nil cells are
+// NULL values.
+type table struct {
+	Columns []column    `json:"columns"`
+	Rows    [][]*string `json:"rows"`
+}
+
+// table returns the ResultSet contents, with values as text.
+func (r *ResultSet) table() table {
+	t := table{Columns: make([]column, len(r.Cols)), Rows: [][]*string{}}
+
+	for i, name := range r.Cols {
+		t.Columns[i].Name = name
+		if i < len(r.Types) {
+			t.Columns[i].Type = r.Types[i]
+			t.Columns[i].OID = typeOIDs[r.Types[i]]
+		}
+	}
+	for _, row := range r.Rows {
+		cells := make([]*string, len(row))
+		for i, value := range row {
+			if value != nil {
+				s := valueToString(value)
+				cells[i] = &s
+			}
+		}
+		t.Rows = append(t.Rows, cells)
+	}
+	return t
+}
+
+// JSON returns the result set as a JSON document, with the columns names
+// and types, and a line per row so that the files are easy to diff:
+//
+//	{
+//	  "columns": [
+//	    {"name":"id","type":"int4","oid":23}
+//	  ],
+//	  "rows": [
+//	    ["1"]
+//	  ]
+//	}
+func (r *ResultSet) JSON() (string, error) {
+	var b bytes.Buffer
+	t := r.table()
+
+	writeList := func(key string, n int, item func(i int) (interface{}, error)) error {
+		fmt.Fprintf(&b, "  %q: [", key)
+		for i := 0; i < n; i++ {
+			v, err := item(i)
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "\n    %s", data)
+		}
+		if n > 0 {
+			b.WriteString("\n  ")
+		}
+		b.WriteString("]")
+		return nil
+	}
+
+	b.WriteString("{\n")
+	err := writeList("columns", len(t.Columns), func(i int) (interface{}, error) {
+		return t.Columns[i], nil
+	})
+	if err != nil {
+		return "", err
+	}
+	b.WriteString(",\n")
+	err = writeList("rows", len(t.Rows), func(i int) (interface{}, error) {
+		return t.Rows[i], nil
+	})
+	if err != nil {
+		return "", err
+	}
+	b.WriteString("\n}\n")
+	return b.String(), nil
+}
+
+// CSV returns the result set in the CSV format, with a first record of
+// columns names, a second record of columns type OIDs, and then a record per
+// row. NULL values are written \N, and a \N text value \\N.
+func (r *ResultSet) CSV() (string, error) {
+	var b bytes.Buffer
+	t := r.table()
+	w := csv.NewWriter(&b)
+
+	names := make([]string, len(t.Columns))
+	oids := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		names[i] = col.Name
+		oids[i] = strconv.FormatUint(uint64(col.OID), 10)
+	}
+	w.Write(names)
+	w.Write(oids)
+
+	for _, row := range t.Rows {
+		record := make([]string, len(row))
+		for i, cell := range row {
+			record[i] = csvField(cell)
+		}
+		if len(record) == 1 && record[0] == "" {
+			// an empty line would be skipped when reading the file
+			w.Flush()
+			b.WriteString("\"\"\n")
+			continue
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return b.String(), w.Error()
+}
+
+// contents returns the text of the result set to write in filename, in the
+// format that matches the filename extension.
+func (r *ResultSet) contents(filename string) (string, error) {
+	switch filepath.Ext(filename) {
+	case ".json":
+		return r.JSON()
+	case ".csv":
+		return r.CSV()
+	}
+	return r.PrettyPrint(), nil
+}
+
+// readTable reads a structured result file, in the format that matches its
+// extension.
+func readTable(filename string) (table, error) {
+	var t table
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return t, fmt.Errorf("Failed to read result set '%s': %s\n", filename, err)
+	}
+
+	switch filepath.Ext(filename) {
+	case ".json":
+		if err := json.Unmarshal(data, &t); err != nil {
+			return t, fmt.Errorf("Failed to parse result set '%s': %s\n", filename, err)
+		}
+
+	case ".csv":
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return t, fmt.Errorf("Failed to parse result set '%s': %s\n", filename, err)
+		}
+		if len(records) < 2 {
+			return t, fmt.Errorf("Failed to parse result set '%s': missing header\n", filename)
+		}
+		for i, name := range records[0] {
+			o, _ := strconv.ParseUint(records[1][i], 10, 32)
+			col := column{Name: name, OID: oid.Oid(o)}
+			col.Type = strings.ToLower(oid.TypeName[col.OID])
+			t.Columns = append(t.Columns, col)
+		}
+		for _, record := range records[2:] {
+			cells := make([]*string, len(record))
+			for i := range record {
+				cells[i] = csvCell(record[i])
+			}
+			t.Rows = append(t.Rows, cells)
+		}
+
+	default:
+		return t, fmt.Errorf("Unknown result format for '%s'\n", filename)
+	}
+	return t, nil
+}
+
+// cellString returns a cell value for error reports.
+func cellString(cell *string) string {
+	if cell == nil {
+		return "NULL"
+	}
+	return strconv.Quote(*cell)
+}
+
+/*
+CompareTables compares the structured result files expected and actual
+cell by cell, and returns a report of the differences, naming the row and
+column of each changed value, or an empty string when the result sets are
+the same:
+
+	row 3, column "name": expected "AC/DC", got "ACDC"
+
+When the Unordered option is set, the rows are sorted before comparing
+them, and paired within tolerance when set, see matchRows. Numeric values
+are compared with the Tolerance options.
+*/
+func CompareTables(expected string, actual string, o Options) (string, error) {
+	e, err := readTable(expected)
+	if err != nil {
+		return "", err
+	}
+	a, err := readTable(actual)
+	if err != nil {
+		return "", err
+	}
+	if o.Unordered {
+		e.sortRows()
+		a.sortRows()
+		if len(o.Tolerance) > 0 {
+			e.Rows, a.Rows = o.matchRows(e.Columns, e.Rows, a.Rows)
+		}
+	}
+	return compareTables(e, a, o), nil
+}
+
+// compareTables returns the differences between the tables e and a.
+func compareTables(e table, a table, o Options) string {
+	var b strings.Builder
+
+	if len(e.Columns) != len(a.Columns) {
+		fmt.Fprintf(&b, "expected %d columns, got %d\n", len(e.Columns), len(a.Columns))
+	}
+	for i := 0; i < len(e.Columns) && i < len(a.Columns); i++ {
+		ec, ac := e.Columns[i], a.Columns[i]
+		if ec.Name != ac.Name {
+			fmt.Fprintf(&b, "column %d: expected name %q, got %q\n", i+1, ec.Name, ac.Name)
+		}
+		if ec.OID != ac.OID {
+			fmt.Fprintf(&b, "column %q: expected type %s, got %s\n", ec.Name, ec.Type, ac.Type)
+		}
+	}
+	if b.Len() > 0 {
+		// cells are not comparable when the columns changed
+		return b.String()
+	}
+
+	for i := 0; i < len(e.Rows) && i < len(a.Rows); i++ {
+		for j, col := range e.Columns {
+			var ec, ac *string
+			if j < len(e.Rows[i]) {
+				ec = e.Rows[i][j]
+			}
+			if j < len(a.Rows[i]) {
+				ac = a.Rows[i][j]
+			}
+			if (ec == nil) != (ac == nil) || (ec != nil && !o.cellsEqual(col.Name, *ec, *ac)) {
+				fmt.Fprintf(&b, "row %d, column %q: expected %s, got %s\n",
+					i+1, col.Name, cellString(ec), cellString(ac))
+			}
+		}
+	}
+	for i := len(a.Rows); i < len(e.Rows); i++ {
+		fmt.Fprintf(&b, "row %d: missing %s\n", i+1, rowString(e.Rows[i]))
+	}
+	for i := len(e.Rows); i < len(a.Rows); i++ {
+		fmt.Fprintf(&b, "row %d: unexpected %s\n", i+1, rowString(a.Rows[i]))
+	}
+	return b.String()
+}
+
+// rowString returns a row for error reports.
+func rowString(row []*string) string {
+	cells := make([]string, len(row))
+	for i, cell := range row {
+		cells[i] = cellString(cell)
+	}
+	return "(" + strings.Join(cells, ", ") + ")"
+}
+
+// sortRows sorts the table rows, NULL values sorting first.
+func (t *table) sortRows() {
+	key := func(row []*string) []string {
+		k := make([]string, len(row))
+		for i, cell := range row {
+			if cell != nil {
+				// keep NULL apart from the empty string
+				k[i] = "\x01" + *cell
+			}
+		}
+		return k
+	}
+	sort.SliceStable(t.Rows, func(i, j int) bool {
+		return lessCells(key(t.Rows[i]), key(t.Rows[j]))
+	})
+}
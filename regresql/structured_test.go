@@ -0,0 +1,117 @@
+package regresql
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// structuredResultSet returns a small ResultSet with a NULL value.
+func structuredResultSet() *ResultSet {
+	return &ResultSet{
+		Cols:  []string{"id", "name"},
+		Types: []string{"int4", "text"},
+		Rows:  [][]interface{}{{int64(1), "AC/DC"}, {int64(2), nil}},
+	}
+}
+
+func TestResultSetJSON(t *testing.T) {
+	out, err := structuredResultSet().JSON()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := `{
+  "columns": [
+    {"name":"id","type":"int4","oid":23},
+    {"name":"name","type":"text","oid":25}
+  ],
+  "rows": [
+    ["1","AC/DC"],
+    ["2",null]
+  ]
+}
+`
+	if out != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestCompareTables(t *testing.T) {
+	for _, ext := range []string{".json", ".csv"} {
+		dir := t.TempDir()
+		expected := filepath.Join(dir, "expected"+ext)
+		actual := filepath.Join(dir, "actual"+ext)
+
+		rs := structuredResultSet()
+		if err := rs.Write(expected, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.Write(actual, true); err != nil {
+			t.Fatal(err)
+		}
+		if diff, err := CompareTables(expected, actual, Options{}); err != nil || diff != "" {
+			t.Errorf("%s: expected no differences, got %q (%v)", ext, diff, err)
+		}
+
+		rs.Rows = [][]interface{}{{int64(2), ""}, {int64(1), "ACDC"}, {int64(3), "new"}}
+		if err := rs.Write(actual, true); err != nil {
+			t.Fatal(err)
+		}
+
+		diff, err := CompareTables(expected, actual, Options{Unordered: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := `row 1, column "name": expected "AC/DC", got "ACDC"
+row 2, column "name": expected NULL, got ""
+row 3: unexpected ("3", "new")
+`
+		if diff != report {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", ext, report, diff)
+		}
+	}
+}
+
+func TestCompareTablesColumns(t *testing.T) {
+	dir := t.TempDir()
+	expected := filepath.Join(dir, "expected.csv")
+	actual := filepath.Join(dir, "actual.csv")
+
+	if err := ioutil.WriteFile(expected, []byte("id,name\n23,25\n1,a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(actual, []byte("id,name\n20,25\n1,a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := CompareTables(expected, actual, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := "column \"id\": expected type int4, got int8\n"; diff != report {
+		t.Errorf("Expected %q, got %q", report, diff)
+	}
+}
+
+func TestCSVNull(t *testing.T) {
+	dir := t.TempDir()
+	rs := &ResultSet{
+		Cols:  []string{"value"},
+		Types: []string{"text"},
+		Rows:  [][]interface{}{{nil}, {`\N`}, {`\\N`}, {""}},
+	}
+	filename := filepath.Join(dir, "null.csv")
+	if err := rs.Write(filename, true); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := readTable(filename)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	for i, row := range rs.Rows {
+		cell := table.Rows[i][0]
+		if row[0] == nil && cell != nil || row[0] != nil && (cell == nil || *cell != row[0]) {
+			t.Errorf("row %d: expected %v, got %s", i+1, row[0], cellString(cell))
+		}
+	}
+}
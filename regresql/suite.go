@@ -1,13 +1,15 @@
 package regresql
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 
 	_ "github.com/lib/pq"
-	"github.com/mndrix/tap-go"
 )
 
 /*
@@ -39,6 +41,10 @@ type Suite struct {
 	PlanDir     string
 	ExpectedDir string
 	OutDir      string
+
+	cases map[string]map[string]bool // test cases selected per query file
+	run   *regexp.Regexp             // test names selected with --run
+	out   io.Writer                  // progress messages, standard output when nil
 }
 
 /*
@@ -57,7 +63,7 @@ func newSuite(root string) *Suite {
 	planDir := filepath.Join(root, "regresql", "plans")
 	expectedDir := filepath.Join(root, "regresql", "expected")
 	outDir := filepath.Join(root, "regresql", "out")
-	return &Suite{root, regressDir, folders, planDir, expectedDir, outDir}
+	return &Suite{root, regressDir, folders, planDir, expectedDir, outDir, nil, nil, nil}
 }
 
 // newFolder created a new Folder instance
@@ -102,11 +108,13 @@ func Walk(root string) *Suite {
 // without moving the regresql/ hierarchy.
 //
 // exclude is a list of glob patterns (relative to root) for SQL files to skip.
+// The setup.sql and teardown.sql scripts are not query files, and are
+// skipped too.
 func WalkFrom(root, scanRoot string, exclude []string) *Suite {
 	suite := newSuite(root)
 
 	visit := func(path string, f os.FileInfo, err error) error {
-		if filepath.Ext(path) == ".sql" {
+		if filepath.Ext(path) == ".sql" && !isHookScript(path) {
 			relPath, _ := filepath.Rel(root, path)
 			for _, pattern := range exclude {
 				if matched, _ := filepath.Match(pattern, relPath); matched {
@@ -140,20 +148,20 @@ func (s *Suite) initRegressHierarchy() error {
 	for _, folder := range s.Dirs {
 		rdir := filepath.Join(s.PlanDir, folder.Dir)
 
-		if err := maybeMkdirAll(rdir); err != nil {
+		if err := s.maybeMkdirAll(rdir); err != nil {
 			return fmt.Errorf("Failed to create test plans directory: %s", err)
 		}
 
 		for _, name := range folder.Files {
 			qfile := filepath.Join(s.Root, folder.Dir, name)
 
-			q, err := parseQueryFile(qfile)
+			queries, err := parseQueriesFile(qfile)
 
 			if err != nil {
 				return err
 			}
 
-			if _, err := q.CreateEmptyPlan(rdir); err != nil {
+			if _, err := createEmptyPlans(queries, rdir); err != nil {
 				fmt.Println("Skipping:", err)
 			}
 		}
@@ -161,19 +169,82 @@ func (s *Suite) initRegressHierarchy() error {
 	return nil
 }
 
+// getPlans parses the query file name found in folder and returns the Plan
+// of each of its queries, with options set from the config defaults and the
+// query directives, and only the test cases selected by the Suite filter.
+func (s *Suite) getPlans(folder Folder, name string, config config) ([]*Plan, error) {
+	plans, err := s.loadPlans(folder, name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []*Plan
+	for _, p := range plans {
+		if s.selectCases(filepath.Join(folder.Dir, name), p) {
+			selected = append(selected, p)
+		}
+	}
+	return selected, nil
+}
+
+// loadPlans parses the query file name found in folder and returns the Plan
+// of each of its queries, with options set from the config defaults and the
+// query directives.
+func (s *Suite) loadPlans(folder Folder, name string, config config) ([]*Plan, error) {
+	qfile := filepath.Join(s.Root, folder.Dir, name)
+	rdir := filepath.Join(s.PlanDir, folder.Dir)
+
+	queries, err := parseQueriesFile(qfile)
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []*Plan
+	for _, q := range queries {
+		p, err := q.GetPlan(rdir)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.SetOptions(config.Options); err != nil {
+			return nil, err
+		}
+		plans = append(plans, p)
+	}
+	return plans, nil
+}
+
+// openDB opens the connection pool to pguri, allowing as many connections
+// as we run jobs concurrently.
+func openDB(pguri string, jobs int) (*sql.DB, error) {
+	db, err := sql.Open("postgres", pguri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to '%s': %s\n", redactPgUri(pguri), err)
+	}
+	if jobs > 0 {
+		db.SetMaxOpenConns(jobs)
+	}
+	return db, nil
+}
+
 // createExpectedResults walks the s Suite instance and runs its queries,
-// storing the results in the expected files.
+// storing the results in the expected files. Up to opts.Jobs query files
+// are run concurrently, between the setup and teardown scripts.
 //
 // versionedFiles is a set of SQL file paths (relative to suite root) that
 // should produce version-specific expected output (e.g. query.pg16.out).
 // Pass nil or an empty map to write generic .out files for all queries.
-func (s *Suite) createExpectedResults(pguri string, versionedFiles map[string]bool) error {
-	db, err := sql.Open("postgres", pguri)
-
+//
+// When ctx is done, the query in flight is canceled and the run stops.
+//
+// A binding that fails to run doesn't stop the others, and its expected file
+// is left alone, but an error is returned once they have all run.
+func (s *Suite) createExpectedResults(ctx context.Context, config config, t target, opts RunOptions, versionedFiles map[string]bool) error {
+	db, closeDB, err := t.open(opts.Jobs)
 	if err != nil {
-		return fmt.Errorf("Failed to connect to '%s': %s\n", pguri, err)
+		return err
 	}
-	defer db.Close()
+	defer closeDB()
 
 	pgMajor := 0
 	if len(versionedFiles) > 0 {
@@ -184,51 +255,83 @@ func (s *Suite) createExpectedResults(pguri string, versionedFiles map[string]bo
 		}
 	}
 
-	fmt.Println("Writing expected Result Sets:")
+	s.printf("Writing expected Result Sets:\n")
 
 	for _, folder := range s.Dirs {
-		rdir := filepath.Join(s.PlanDir, folder.Dir)
-		edir := filepath.Join(s.ExpectedDir, folder.Dir)
-		maybeMkdirAll(edir)
-
-		fmt.Printf("  %s\n", edir)
-
-		for _, name := range folder.Files {
-			qfile := filepath.Join(s.Root, folder.Dir, name)
-			relPath := filepath.Join(folder.Dir, name)
+		s.maybeMkdirAll(filepath.Join(s.ExpectedDir, folder.Dir))
+	}
 
-			q, err := parseQueryFile(qfile)
+	run := func(job queryJob) ([]*Plan, error) {
+		edir := filepath.Join(s.ExpectedDir, job.folder.Dir)
+		relPath := filepath.Join(job.folder.Dir, job.name)
 
-			if err != nil {
-				return err
-			}
-
-			p, err := q.GetPlan(rdir)
-			if err != nil {
-				return err
+		plans, err := s.getPlans(job.folder, job.name, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range plans {
+			if p.ExecuteContext(ctx, db); ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
-			p.Execute(db)
 
 			filePgMajor := 0
 			if versionedFiles[relPath] {
 				filePgMajor = pgMajor
 			}
-			p.WriteResultSets(edir, filePgMajor)
+			if err := p.WriteResultSets(edir, filePgMajor); err != nil {
+				return nil, err
+			}
 
+			if len(p.ResultSets) > 0 {
+				if err := p.WriteTimings(edir); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return plans, nil
+	}
+
+	currentDir := ""
+	failures := 0
+	report := func(job queryJob, plans []*Plan) error {
+		if edir := filepath.Join(s.ExpectedDir, job.folder.Dir); edir != currentDir {
+			s.printf("  %s\n", edir)
+			currentDir = edir
+		}
+		for _, p := range plans {
 			for _, rs := range p.ResultSets {
-				fmt.Printf("    %s\n", filepath.Base(rs.Filename))
+				if rs.Error != "" {
+					s.printf("    %s: failed to run\n%s", filepath.Base(rs.Filename), rs.Error)
+					failures++
+					continue
+				}
+				s.printf("    %s\n", filepath.Base(rs.Filename))
 			}
 		}
+		return nil
+	}
+
+	if err := s.runSuite(ctx, db, config, opts.Jobs, run, report); err != nil {
+		return err
+	}
+	if failures > 0 {
+		return fmt.Errorf("Failed to update %d expected result(s), see above\n", failures)
 	}
 	return nil
 }
 
-// ErrTestsFailed is returned by testQueries when one or more TAP tests report
-// "not ok".  The TAP output has already been written to stdout; callers
-// should exit non-zero without printing an additional error message.
-type ErrTestsFailed struct{ Count int }
+// ErrTestsFailed is returned by testQueries when one or more tests fail, or
+// error. The failures have already been reported; callers should exit non-zero
+// without printing an additional error message.
+type ErrTestsFailed struct {
+	Count  int // tests that failed
+	Errors int // tests that errored
+}
 
 func (e *ErrTestsFailed) Error() string {
+	if e.Errors > 0 {
+		return fmt.Sprintf("%d test(s) failed, %d test(s) errored\n", e.Count, e.Errors)
+	}
 	return fmt.Sprintf("%d test(s) failed\n", e.Count)
 }
 
@@ -236,63 +339,135 @@ func (e *ErrTestsFailed) Error() string {
 // and stores results in the out directory for manual inspection if
 // necessary.  It then compares the actual output to the expected output and
 // reports TAP output.  It returns an *ErrTestsFailed when any test reports
-// "not ok", or a plain error for infrastructure failures (connection, I/O,
-// …).
-func (s *Suite) testQueries(pguri string) error {
-	db, err := sql.Open("postgres", pguri)
+// "not ok", an *ErrBailOut when a setup or teardown script fails, or a plain
+// error for infrastructure failures (connection, I/O, …).
+//
+// Up to opts.Jobs query files are run concurrently, the test results are
+// still reported in the Suite order, in the opts.Format format, to the
+// opts.Output file or to standard output.
+//
+// The Suite runs against each of the targets in turn, reporting to the same
+// report, and a bail out stops the whole run, as does ctx being done.
+func (s *Suite) testQueries(ctx context.Context, config config, targets []target, opts RunOptions) error {
+	var w io.Writer = os.Stdout
 
+	if opts.Output != "" {
+		f, err := os.Create(opts.Output)
+		if err != nil {
+			return fmt.Errorf("Failed to create report file '%s': %s\n",
+				opts.Output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	r, err := NewReporter(opts.Format, w)
 	if err != nil {
-		return fmt.Errorf("Failed to connect to '%s': %s\n", pguri, err)
+		return err
 	}
-	defer db.Close()
+	return s.runTests(ctx, config, targets, opts, r)
+}
 
-	pgMajor, _ := GetPgMajorVersion(db)
+// runTests runs the Suite tests against each of the targets and reports
+// the results to r, see testQueries.
+func (s *Suite) runTests(ctx context.Context, config config, targets []target, opts RunOptions, r Reporter) error {
+	r.Header()
 
-	t := tap.New()
-	t.Header(0)
+	failures, errors := 0, 0
+	for _, t := range targets {
+		failed, errored, err := s.testTarget(ctx, config, t, opts, targetReporter{r, t.Name})
+		failures += failed
+		errors += errored
 
-	failures := 0
+		if err != nil {
+			if bail, ok := err.(*ErrBailOut); ok {
+				r.BailOut(bail.Reason)
+				r.Finish()
+			}
+			return err
+		}
+	}
+	if err := r.Finish(); err != nil {
+		return err
+	}
 
-	for _, folder := range s.Dirs {
-		rdir := filepath.Join(s.PlanDir, folder.Dir)
-		edir := filepath.Join(s.ExpectedDir, folder.Dir)
-		odir := filepath.Join(s.OutDir, folder.Dir)
-		maybeMkdirAll(odir)
+	if failures > 0 || errors > 0 {
+		return &ErrTestsFailed{Count: failures, Errors: errors}
+	}
+	return nil
+}
 
-		for _, name := range folder.Files {
-			qfile := filepath.Join(s.Root, folder.Dir, name)
+// testTarget runs the Suite queries against the target database, reports
+// the test results to r, and returns how many tests failed, and how many
+// errored. Queries that fail to run are reported as errored tests, and
+// don't stop the run.
+func (s *Suite) testTarget(ctx context.Context, config config, t target, opts RunOptions, r Reporter) (int, int, error) {
+	db, closeDB, err := t.open(opts.Jobs)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer closeDB()
 
-			q, err := parseQueryFile(qfile)
+	pgMajor, _ := GetPgMajorVersion(db)
 
-			if err != nil {
-				return err
-			}
+	outDir := t.outDir(s)
+	for _, folder := range s.Dirs {
+		s.maybeMkdirAll(filepath.Join(outDir, folder.Dir))
+	}
+	if err := writePgVersion(outDir, pgMajor); err != nil {
+		return 0, 0, err
+	}
 
-			p, err := q.GetPlan(rdir)
-			if err != nil {
-				return err
-			}
-			if err := p.Execute(db); err != nil {
-				return err
+	run := func(job queryJob) ([]*Plan, error) {
+		odir := filepath.Join(outDir, job.folder.Dir)
+
+		plans, err := s.getPlans(job.folder, job.name, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range plans {
+			// errors are reported as tests
+			if p.ExecuteContext(ctx, db); ctx.Err() != nil {
+				return nil, ctx.Err()
 			}
+
 			if err := p.WriteResultSets(odir, 0); err != nil {
-				return err
+				return nil, err
 			}
-			failures += p.CompareResultSets(s.RegressDir, edir, t, pgMajor)
+			p.removeErrored()
 		}
+		return plans, nil
 	}
 
-	if failures > 0 {
-		return &ErrTestsFailed{Count: failures}
+	failures, errors := 0, 0
+	report := func(job queryJob, plans []*Plan) error {
+		edir := filepath.Join(s.ExpectedDir, job.folder.Dir)
+		for _, p := range plans {
+			failed, errored := p.CompareResultSets(s.RegressDir, edir, r, pgMajor)
+			failures += failed
+			errors += errored
+		}
+		return nil
 	}
-	return nil
+
+	err = s.runSuite(ctx, db, config, opts.Jobs, run, report)
+	return failures, errors, err
+}
+
+// printf prints a progress message to the Suite output.
+func (s *Suite) printf(format string, a ...interface{}) {
+	w := s.out
+	if w == nil {
+		w = os.Stdout
+	}
+	fmt.Fprintf(w, format, a...)
 }
 
 // Only create dir(s) when it doesn't exists already
-func maybeMkdirAll(dir string) error {
+func (s *Suite) maybeMkdirAll(dir string) error {
 	stat, err := os.Stat(dir)
 	if err != nil || !stat.IsDir() {
-		fmt.Printf("Creating directory '%s'\n", dir)
+		s.printf("Creating directory '%s'\n", dir)
 
 		err := os.MkdirAll(dir, 0755)
 
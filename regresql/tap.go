@@ -2,80 +2,80 @@ package regresql
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
-	"strings"
+	"io"
 
 	"github.com/mndrix/tap-go"
 )
 
-/*
-CompareResultsSets load the expected result set and compares it with the
-given Plan's ResultSet, and fills in a tap.T test output.
-
-The test is considered passed when the diff is empty.
-
-When pgMajor > 0, a version-specific expected file (e.g. query.pg16.out) is
-checked first; the generic file (query.out) is used as fallback.
+// tapReporter outputs test results in the TAP format, see
+// https://testanything.org.
+type tapReporter struct {
+	t       *tap.T
+	passed  int
+	failed  int
+	errored int
+}
 
-Rather than returning an error in case something wrong happens, we register
-a diagnostic against the tap output and fail the test case.
-*/
-// CompareResultSets compares each result set in the plan against its expected
-// file and reports TAP output.  It returns the number of failing tests so the
-// caller can propagate a non-zero exit code.
-func (p *Plan) CompareResultSets(regressDir string, expectedDir string, t *tap.T, pgMajor int) int {
-	failures := 0
-	for i, rs := range p.ResultSets {
-		testName := strings.TrimPrefix(rs.Filename, regressDir+"/out/")
-		base := filepath.Base(rs.Filename)
-		expectedFilename := filepath.Join(expectedDir, base)
+func newTapReporter(w io.Writer) *tapReporter {
+	t := tap.New()
+	t.Writer = w
+	return &tapReporter{t: t}
+}
 
-		if pgMajor > 0 {
-			ext := filepath.Ext(base)
-			stem := strings.TrimSuffix(base, ext)
-			versioned := filepath.Join(expectedDir,
-				fmt.Sprintf("%s.pg%d%s", stem, pgMajor, ext))
-			if _, err := os.Stat(versioned); err == nil {
-				expectedFilename = versioned
-			}
-		}
+// Header outputs the TAP version line. As we don't know in advance how many
+// tests we are going to run, no plan is written.
+func (r *tapReporter) Header() {
+	r.t.Header(0)
+}
 
-		diff, err := DiffFiles(expectedFilename, rs.Filename, 3)
+// tapTiming is the YAML block output after each TAP test line.
+type tapTiming struct {
+	Duration float64 `json:"duration_ms"`
+	Baseline float64 `json:"baseline_ms,omitempty"`
+	Slow     string  `json:"slow,omitempty"`
+}
 
-		// p.Names and p.Bindings are empty for parameterless queries; guard
-		// against an out-of-range panic before entering the diagnostic branches.
-		bindingName := ""
-		if i < len(p.Names) {
-			bindingName = p.Names[i]
-		}
-		var bindingParams interface{} = map[string]string{}
-		if i < len(p.Bindings) {
-			bindingParams = p.Bindings[i]
-		}
+// Report outputs a TAP test line for result, after a diagnostic when the
+// test failed or is slow, and followed by a YAML block with its duration.
+func (r *tapReporter) Report(result TestResult) {
+	switch {
+	case result.Errored:
+		r.errored++
+	case !result.Passed:
+		r.failed++
+	default:
+		r.passed++
+	}
 
-		if err != nil {
-			t.Diagnostic(
-				fmt.Sprintf(`Query File: '%s'
+	label := "Failed"
+	if result.Errored {
+		label = "Error"
+	} else if result.TimedOut {
+		label = "Timeout"
+	}
+	if result.Error != "" {
+		r.t.Diagnostic(
+			fmt.Sprintf(`Query File: '%s'
 Bindings File: '%s'
 Bindings Name: '%s'
 Query Parameters: '%v'
 Expected Result File: '%s'
 Actual Result File: '%s'
 
-Failed to compare results: %s`,
-					p.Query.Path,
-					p.Path,
-					bindingName,
-					bindingParams,
-					expectedFilename,
-					rs.Filename,
-					err.Error()))
-		}
+%s: %s`,
+				result.QueryFile,
+				result.PlanFile,
+				result.Binding,
+				result.Params,
+				result.ExpectedFile,
+				result.ActualFile,
+				label,
+				result.Error))
+	}
 
-		if diff != "" {
-			t.Diagnostic(
-				fmt.Sprintf(`Query File: '%s'
+	if result.Diff != "" {
+		r.t.Diagnostic(
+			fmt.Sprintf(`Query File: '%s'
 Bindings File: '%s'
 Bindings Name: '%s'
 Query Parameters: '%v'
@@ -83,19 +83,34 @@ Expected Result File: '%s'
 Actual Result File: '%s'
 
 %s`,
-					p.Query.Path,
-					p.Path,
-					bindingName,
-					bindingParams,
-					expectedFilename,
-					rs.Filename,
-					diff))
-		}
-		passed := diff == ""
-		if !passed {
-			failures++
-		}
-		t.Ok(passed, testName)
+				result.QueryFile,
+				result.PlanFile,
+				result.Binding,
+				result.Params,
+				result.ExpectedFile,
+				result.ActualFile,
+				result.Diff))
 	}
-	return failures
+	if result.Slow != "" {
+		r.t.Diagnostic(fmt.Sprintf("Performance regression: %s", result.Slow))
+	}
+	r.t.Ok(result.Passed, result.Name)
+	r.t.YAML(tapTiming{
+		Duration: milliseconds(result.Duration),
+		Baseline: milliseconds(result.Baseline),
+		Slow:     result.Slow,
+	})
+}
+
+// BailOut outputs a TAP bail out line, telling the TAP consumer that the
+// test run is aborted.
+func (r *tapReporter) BailOut(reason string) {
+	fmt.Fprintf(r.t.Writer, "Bail out! %s\n", reason)
+}
+
+// Finish outputs a summary diagnostic, results are output as they come.
+func (r *tapReporter) Finish() error {
+	r.t.Diagnostic(fmt.Sprintf("%d passed, %d failed, %d errored",
+		r.passed, r.failed, r.errored))
+	return nil
 }
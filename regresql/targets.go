@@ -0,0 +1,143 @@
+package regresql
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// allTargets is the --target value that selects every target of the
+// configuration file.
+const allTargets = "all"
+
+/*
+A target is a database to run the tests against. Targets are named in the
+regress.yaml configuration file:
+
+	pguri: postgres:///chinook
+	targets:
+	  pg13: postgres://localhost:5413/chinook
+	  pg16: postgres://localhost:5416/chinook
+
+The default target has no name, and connects with pguri. A target may also
+use a connection pool opened by the caller, see Runner.
+*/
+type target struct {
+	Name  string
+	PgUri string
+
+	db *sql.DB // used rather than connecting to PgUri, when set
+}
+
+/*
+getTargets returns the targets selected by names, a comma separated list
+of target names, or "all" for every target of the configuration, sorted by
+name. When names is empty, the default target is returned.
+*/
+func (c config) getTargets(names string) ([]target, error) {
+	if names == "" {
+		return []target{{PgUri: c.PgUri}}, nil
+	}
+
+	var selected []string
+	if names == allTargets {
+		for name := range c.Targets {
+			selected = append(selected, name)
+		}
+		sort.Strings(selected)
+	} else {
+		selected = strings.Split(names, ",")
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("No targets found in the configuration file\n")
+	}
+
+	var targets []target
+	for _, name := range selected {
+		name = strings.TrimSpace(name)
+		pguri, ok := c.Targets[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("Target '%s' not found in the configuration file\n", name)
+		}
+		targets = append(targets, target{Name: strings.ToLower(name), PgUri: pguri})
+	}
+	return targets, nil
+}
+
+// outDir returns the directory where to write the actual result files of
+// the target: the default target uses the Suite OutDir, named targets use
+// a subdirectory of their own, so that test names begin with the target
+// name.
+func (t target) outDir(s *Suite) string {
+	if t.Name == "" {
+		return s.OutDir
+	}
+	return filepath.Join(s.OutDir, t.Name)
+}
+
+// pgVersionFile is the file where Test() records the PostgreSQL major
+// version of a target, in its out directory, so that the expected files
+// the results are compared to can be found again without connecting to it.
+const pgVersionFile = "PG_VERSION"
+
+// writePgVersion records pgMajor in the pgVersionFile of outDir, or removes
+// the file when the version is unknown.
+func writePgVersion(outDir string, pgMajor int) error {
+	filename := filepath.Join(outDir, pgVersionFile)
+	if pgMajor == 0 {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove '%s': %s\n", filename, err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("Failed to create directory '%s': %s\n", outDir, err)
+	}
+	data := []byte(strconv.Itoa(pgMajor) + "\n")
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("Failed to write '%s': %s\n", filename, err)
+	}
+	return nil
+}
+
+// readPgVersion returns the PostgreSQL major version recorded in outDir by
+// writePgVersion, or 0 when unknown.
+func readPgVersion(outDir string) int {
+	data, err := ioutil.ReadFile(filepath.Join(outDir, pgVersionFile))
+	if err != nil {
+		return 0
+	}
+	pgMajor, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pgMajor
+}
+
+// open returns the connection pool of the target, see openDB, and the
+// function to call to close it, which leaves alone a pool given by the
+// caller.
+func (t target) open(jobs int) (*sql.DB, func() error, error) {
+	if t.db != nil {
+		return t.db, func() error { return nil }, nil
+	}
+	db, err := openDB(t.PgUri, jobs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, db.Close, nil
+}
+
+// targetReporter sets the target name of the results it reports.
+type targetReporter struct {
+	Reporter
+	name string
+}
+
+func (r targetReporter) Report(result TestResult) {
+	result.Target = r.name
+	r.Reporter.Report(result)
+}
@@ -0,0 +1,146 @@
+package regresql
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetTargets(t *testing.T) {
+	s := writeConfig(t, `pguri: postgres:///chinook
+targets:
+  pg16: postgres://localhost:5416/chinook
+  PG13: postgres://localhost:5413/chinook
+`)
+	config, err := s.readConfig()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	tests := []struct {
+		names    string
+		expected string
+	}{
+		{"", "=postgres:///chinook"},
+		{"all", "pg13=postgres://localhost:5413/chinook pg16=postgres://localhost:5416/chinook"},
+		{"pg16", "pg16=postgres://localhost:5416/chinook"},
+		{"pg16, PG13", "pg16=postgres://localhost:5416/chinook pg13=postgres://localhost:5413/chinook"},
+	}
+	for _, test := range tests {
+		targets, err := config.getTargets(test.names)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.names, err)
+			continue
+		}
+		var got []string
+		for _, target := range targets {
+			got = append(got, target.Name+"="+target.PgUri)
+		}
+		if strings.Join(got, " ") != test.expected {
+			t.Errorf("%q: expected targets %q, got %q", test.names, test.expected, got)
+		}
+	}
+
+	if _, err := config.getTargets("pg15"); err == nil {
+		t.Error("Expected an error for an unknown target, got nil")
+	}
+
+	s = writeConfig(t, "pguri: postgres:///chinook\n")
+	config, _ = s.readConfig()
+	if _, err := config.getTargets("all"); err == nil {
+		t.Error("Expected an error for --target all without targets, got nil")
+	}
+
+	s = writeConfig(t, "pguri: postgres:///chinook\ntargets:\n  all: postgres:///other\n")
+	if _, err := s.readConfig(); err == nil {
+		t.Error("Expected an error for a target named all, got nil")
+	}
+}
+
+func TestTargetReporter(t *testing.T) {
+	var b bytes.Buffer
+	r, _ := NewReporter("json", &b)
+
+	for _, name := range []string{"pg13", "pg16"} {
+		tr := targetReporter{r, name}
+		tr.Report(TestResult{Name: name + "/src/sql/artist.out", QueryFile: "src/sql/artist.sql", Passed: true})
+	}
+	if err := r.Finish(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !strings.Contains(b.String(), `"target": "pg16"`) {
+		t.Errorf("Expected results reported per target, got %s", b.String())
+	}
+}
+
+func TestTargetResultPairs(t *testing.T) {
+	s := filterSuite(t)
+	if err := s.filter(config{}, Filter{Paths: []string{"src/sql/artist.sql"}}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	c := config{Targets: map[string]string{
+		"pg13": "postgres:///pg13",
+		"pg16": "postgres:///pg16",
+	}}
+	targets, err := c.getTargets("pg16")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	pairs, err := s.resultPairs(c, targets, 0)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	if len(pairs) != 2 {
+		t.Fatalf("Expected 2 result pairs, got %+v", pairs)
+	}
+	pair := pairs[0]
+	if pair.Name != "pg16/src/sql/artist.red-hot.out" ||
+		pair.Actual != s.OutDir+"/pg16/src/sql/artist.red-hot.out" ||
+		pair.Expected != s.ExpectedDir+"/src/sql/artist.red-hot.out" ||
+		pair.Accept != pair.Expected {
+		t.Errorf("Expected the pg16 target result files, got %+v", pair)
+	}
+
+	// the tests recorded the version of each target, and pg16 has its own
+	// expected files
+	for name, pgMajor := range map[string]int{"pg13": 13, "pg16": 16} {
+		if err := writePgVersion(filepath.Join(s.OutDir, name), pgMajor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versioned := s.ExpectedDir + "/src/sql/artist.red-hot.pg16.out"
+	if err := os.MkdirAll(filepath.Dir(versioned), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(versioned, []byte("pg16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	targets, _ = c.getTargets("all")
+	if pairs, err = s.resultPairs(c, targets, 0); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := map[string][2]string{
+		"pg13/src/sql/artist.red-hot.out": {"artist.red-hot.out", "artist.red-hot.pg13.out"},
+		"pg16/src/sql/artist.red-hot.out": {"artist.red-hot.pg16.out", "artist.red-hot.pg16.out"},
+	}
+	for _, pair := range pairs {
+		files, ok := expected[pair.Name]
+		if !ok {
+			continue
+		}
+		delete(expected, pair.Name)
+		if filepath.Base(pair.Expected) != files[0] || filepath.Base(pair.Accept) != files[1] {
+			t.Errorf("%s: expected to compare to %s and accept into %s, got %+v",
+				pair.Name, files[0], files[1], pair)
+		}
+	}
+	if len(expected) > 0 {
+		t.Errorf("Expected result pairs for %v, got %+v", expected, pairs)
+	}
+}
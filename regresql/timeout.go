@@ -0,0 +1,83 @@
+package regresql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// timeoutGrace is how long to wait past the Timeout option before
+// canceling a query from the client side, giving a chance to the server
+// side statement_timeout to cancel it first.
+const timeoutGrace = time.Second
+
+// parseTimeout parses the timeout directive argument, a duration such as
+// 30s or 500ms, or off to disable the timeout. In plan files, YAML reads an
+// unquoted off as false.
+func parseTimeout(args []string) (time.Duration, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("timeout expects one argument, got %v", args)
+	}
+	switch strings.ToLower(args[0]) {
+	case "off", "false":
+		return 0, nil
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", args[0])
+	}
+	return d, nil
+}
+
+// A timeoutError is returned when a query has been canceled because it took
+// longer than the Timeout option.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("Query canceled after the %s timeout", e.timeout)
+}
+
+// queryContext returns the context where to run a query, canceled when the
+// Timeout option, if any, is exceeded.
+func (o Options) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, o.Timeout+timeoutGrace)
+}
+
+// checkTimeout returns a *timeoutError when err is the query being
+// canceled because of the Timeout option, either with the server side
+// statement_timeout or the client side context deadline, and err
+// otherwise. Queries canceled with parent are not timeouts.
+func (o Options) checkTimeout(parent context.Context, err error) error {
+	if err == nil || o.Timeout <= 0 || parent.Err() != nil {
+		return err
+	}
+	var pqErr *pq.Error
+	if errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &pqErr) && pqErr.Code == "57014") {
+		return &timeoutError{o.Timeout}
+	}
+	return err
+}
+
+// interruptContext returns a context that is canceled on the first Ctrl-C,
+// so that the queries in flight are canceled cleanly. A second Ctrl-C then
+// kills the program as usual.
+func interruptContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx
+}
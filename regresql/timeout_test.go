@@ -0,0 +1,137 @@
+package regresql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected time.Duration
+	}{
+		{[]string{"30s"}, 30 * time.Second},
+		{[]string{"500ms"}, 500 * time.Millisecond},
+		{[]string{"off"}, 0},
+	}
+	for _, test := range tests {
+		d, err := parseTimeout(test.args)
+		if err != nil || d != test.expected {
+			t.Errorf("%v: expected %s, got %s (%v)", test.args, test.expected, d, err)
+		}
+	}
+
+	for _, args := range [][]string{nil, {"30"}, {"-1s"}, {"1s", "2s"}} {
+		if _, err := parseTimeout(args); err == nil {
+			t.Errorf("%v: expected an error, got nil", args)
+		}
+	}
+}
+
+func TestConfigTimeout(t *testing.T) {
+	for _, yaml := range []string{"timeout: off\n", "timeout: \"off\"\n"} {
+		config, err := writeConfig(t, "pguri: postgres:///db\n"+yaml).readConfig()
+		if err != nil || config.Timeout != 0 {
+			t.Errorf("%q: expected no timeout, got %s (%v)", yaml, config.Timeout, err)
+		}
+	}
+
+	for _, yaml := range []string{"timeout: 30\n", "timeout: soon\n", "targets: [pg16]\n"} {
+		if _, err := writeConfig(t, "pguri: postgres:///db\n"+yaml).readConfig(); err == nil {
+			t.Errorf("%q: expected an error, got nil", yaml)
+		}
+	}
+}
+
+func TestTimeoutOptions(t *testing.T) {
+	s := writeConfig(t, "pguri: postgres:///db\ntimeout: 30s\n")
+
+	config, err := s.readConfig()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if config.Timeout != 30*time.Second {
+		t.Fatalf("Expected Timeout==30s from regress.yaml, got %s", config.Timeout)
+	}
+
+	q := mustParseQueryString(t, "src/sql/q.sql", "-- regresql: timeout 5s\nSELECT 1;\n")
+	opts, err := q.resolveOptions(config.Options)
+	if err != nil || opts.Timeout != 5*time.Second {
+		t.Errorf("Expected the directive to set Timeout==5s, got %s (%v)", opts.Timeout, err)
+	}
+
+	directives, err := parsePlanDirectives([]byte("regresql:\n  timeout: off\n"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	p := &Plan{Query: q, Directives: directives}
+	if err := p.SetOptions(config.Options); err != nil || p.Options.Timeout != 0 {
+		t.Errorf("Expected the plan to disable the timeout, got %s (%v)", p.Options.Timeout, err)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	opts := Options{Timeout: time.Second}
+	serverErr := &pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"}
+	otherErr := errors.New("driver: bad connection")
+
+	tests := []struct {
+		opts    Options
+		parent  context.Context
+		err     error
+		timeout bool
+	}{
+		{opts, context.Background(), serverErr, true},
+		{opts, context.Background(), context.DeadlineExceeded, true},
+		{opts, context.Background(), otherErr, false},
+		{opts, canceled, serverErr, false},
+		{Options{}, context.Background(), serverErr, false},
+	}
+	for i, test := range tests {
+		err := test.opts.checkTimeout(test.parent, test.err)
+		if _, ok := err.(*timeoutError); ok != test.timeout {
+			t.Errorf("%d: expected timeout=%v, got %v", i, test.timeout, err)
+		}
+	}
+}
+
+func TestCompareTimedOutResultSet(t *testing.T) {
+	dir := t.TempDir()
+	q := mustParseQueryString(t, "src/sql/artist.sql", "SELECT pg_sleep(60);\n")
+	err := &timeoutError{5 * time.Second}
+	p := &Plan{Query: q, Path: "regresql/plans/src/sql/artist.yaml",
+		ResultSets: []ResultSet{{Error: formatQueryError(err, q.Query), TimedOut: true}},
+	}
+	if err := p.WriteResultSets(dir, 0); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	var b bytes.Buffer
+	r, _ := NewReporter("json", &b)
+	failed, errored := p.CompareResultSets(dir, filepath.Join(dir, "expected"), r, 0)
+	if failed != 1 || errored != 0 {
+		t.Errorf("Expected 1 failed test, got %d failed and %d errored", failed, errored)
+	}
+	if err := r.Finish(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	var report jsonReport
+	if err := json.Unmarshal(b.Bytes(), &report); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	result := report.Results[0]
+	if report.Failed != 1 || result.Status != "timeout" || result.Error != err.Error()+"\n" {
+		t.Errorf("Expected the timed out binding in the report, got %+v", report)
+	}
+}
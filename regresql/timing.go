@@ -0,0 +1,174 @@
+package regresql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+/*
+Timing sets the thresholds used to detect performance regressions, by
+comparing how long a query binding takes to run with its baseline, the
+duration recorded by `regresql update`. A test is slow when it takes longer
+than Max, or more than Ratio times its baseline; the Ratio check is skipped
+for durations under Min, which are too short to measure reliably.
+
+Slow tests are reported with a warning, or fail when Action is "fail".
+
+	timing:
+	  ratio: 3
+	  min: 10ms
+	  max: 2s
+	  action: fail
+*/
+type Timing struct {
+	Ratio  float64       `mapstructure:"ratio"`
+	Min    time.Duration `mapstructure:"min"`
+	Max    time.Duration `mapstructure:"max"`
+	Action string        `mapstructure:"action"`
+}
+
+// parseTiming changes t from directive arguments given as key=value pairs.
+func parseTiming(t Timing, args []string) (Timing, error) {
+	for _, arg := range args {
+		kv, err := splitKeyValue("timing", arg)
+		if err != nil {
+			return t, err
+		}
+		switch kv[0] {
+		case "ratio":
+			t.Ratio, err = strconv.ParseFloat(kv[1], 64)
+		case "min":
+			t.Min, err = time.ParseDuration(kv[1])
+		case "max":
+			t.Max, err = time.ParseDuration(kv[1])
+		case "action":
+			if kv[1] != "warn" && kv[1] != "fail" {
+				err = fmt.Errorf("expected warn or fail")
+			}
+			t.Action = kv[1]
+		default:
+			return t, fmt.Errorf("unknown timing setting %q", kv[0])
+		}
+		if err != nil {
+			return t, fmt.Errorf("invalid timing %s value %q", kv[0], kv[1])
+		}
+	}
+	return t, nil
+}
+
+// check returns a message when d is a performance regression, or an empty
+// string. baseline is 0 when unknown.
+func (t Timing) check(d time.Duration, baseline time.Duration) string {
+	if t.Max > 0 && d > t.Max {
+		return fmt.Sprintf("took %s, more than %s", d, t.Max)
+	}
+	if t.Ratio > 0 && baseline > 0 && d >= t.Min &&
+		float64(d) > t.Ratio*float64(baseline) {
+		return fmt.Sprintf("took %s, %.1fx the %s baseline",
+			d, float64(d)/float64(baseline), baseline)
+	}
+	return ""
+}
+
+// fail returns true when slow tests fail, rather than only being reported.
+func (t Timing) fail() bool {
+	return t.Action == "fail"
+}
+
+// getTimingsPath returns the path of the file where to store the baseline
+// durations of the Plan bindings, next to their expected files in dir.
+func getTimingsPath(p *Plan, dir string) string {
+	basename := strings.TrimSuffix(filepath.Base(p.Path), path.Ext(p.Path))
+	return filepath.Join(dir, basename+".timings.yaml")
+}
+
+// timingKey returns the name of the i-th result set of the Plan in its
+// timings file.
+func timingKey(p *Plan, i int) string {
+	return filepath.Base(getResultSetPath(p, "", i, 0))
+}
+
+// readTimings reads the baseline durations of the Plan bindings found in
+// dir. A missing file means there is no baseline yet.
+func readTimings(p *Plan, dir string) (map[string]time.Duration, error) {
+	timings := make(map[string]time.Duration)
+	filename := getTimingsPath(p, dir)
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return timings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read timings '%s': %s\n", filename, err)
+	}
+
+	var ms map[string]float64
+	if err := yaml.Unmarshal(data, &ms); err != nil {
+		return nil, fmt.Errorf("Failed to parse timings '%s': %s\n", filename, err)
+	}
+	for name, v := range ms {
+		timings[name] = time.Duration(v * float64(time.Millisecond))
+	}
+	return timings, nil
+}
+
+/*
+WriteTimings stores how long each binding of the Plan took to run, in
+milliseconds, in a timings file next to the expected files in dir:
+
+	artist.1.out: 1.234
+	artist.2.out: 0.982
+
+The durations of bindings that didn't run, such as when running a
+selection of the tests, are kept.
+
+The baselines are only used to check the Timing ratio, so nothing is written
+when the Plan has none: durations change from a run to the next, and would
+change the timings files each time the expected files are updated.
+*/
+func (p *Plan) WriteTimings(dir string) error {
+	if p.Options.Timing.Ratio <= 0 {
+		return nil
+	}
+	timings, err := readTimings(p, dir)
+	if err != nil {
+		return err
+	}
+	for i, rs := range p.ResultSets {
+		if rs.Error != "" {
+			continue
+		}
+		timings[timingKey(p, i)] = rs.Duration
+	}
+
+	var names []string
+	for name := range timings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out yaml.MapSlice
+	for _, name := range names {
+		ms, _ := strconv.ParseFloat(fmt.Sprintf("%.3f", milliseconds(timings[name])), 64)
+		out = append(out, yaml.MapItem{Key: name, Value: ms})
+	}
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+	filename := getTimingsPath(p, dir)
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("Failed to write timings '%s': %s\n", filename, err)
+	}
+	return nil
+}
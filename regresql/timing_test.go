@@ -0,0 +1,173 @@
+package regresql
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimingCheck(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		timing    Timing
+		d, base   time.Duration
+		regressed bool
+	}{
+		{Timing{}, 100 * ms, 1 * ms, false},
+		{Timing{Ratio: 3}, 2 * ms, 1 * ms, false},
+		{Timing{Ratio: 3}, 4 * ms, 1 * ms, true},
+		{Timing{Ratio: 3}, 4 * ms, 0, false},
+		{Timing{Ratio: 3, Min: 10 * ms}, 4 * ms, 1 * ms, false},
+		{Timing{Max: 500 * ms}, 400 * ms, 0, false},
+		{Timing{Max: 500 * ms}, 600 * ms, 0, true},
+	}
+	for _, test := range tests {
+		slow := test.timing.check(test.d, test.base)
+		if (slow != "") != test.regressed {
+			t.Errorf("%+v: expected check(%s, %s) regression to be %v, got %q",
+				test.timing, test.d, test.base, test.regressed, slow)
+		}
+	}
+}
+
+func TestTimingOptions(t *testing.T) {
+	s := writeConfig(t, "pguri: postgres:///db\ntiming:\n  ratio: 3\n  min: 10ms\n")
+	config, err := s.readConfig()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if config.Timing.Ratio != 3 || config.Timing.Min != 10*time.Millisecond {
+		t.Fatalf("Expected timing ratio 3 and min 10ms from regress.yaml, got %+v", config.Timing)
+	}
+
+	q := mustParseQueryString(t, "src/sql/q.sql",
+		"-- regresql: timing max=1s action=fail\nSELECT 1;\n")
+	opts, err := q.resolveOptions(config.Options)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := Timing{Ratio: 3, Min: 10 * time.Millisecond, Max: time.Second, Action: "fail"}
+	if opts.Timing != expected {
+		t.Errorf("Expected timing %+v, got %+v", expected, opts.Timing)
+	}
+
+	q = mustParseQueryString(t, "src/sql/q.sql", "-- regresql: timing off\nSELECT 1;\n")
+	if opts, err = q.resolveOptions(config.Options); err != nil || opts.Timing != (Timing{}) {
+		t.Errorf("Expected timing off to reset the thresholds, got %+v (%v)", opts.Timing, err)
+	}
+
+	for _, header := range []string{"timing ratio=fast", "timing action=stop", "timing limit=1s"} {
+		q = mustParseQueryString(t, "src/sql/q.sql", "-- regresql: "+header+"\nSELECT 1;\n")
+		if _, err := q.resolveOptions(Options{}); err == nil {
+			t.Errorf("Expected an error for %q, got nil", header)
+		}
+	}
+
+	s = writeConfig(t, "pguri: postgres:///db\ntiming:\n  action: stop\n")
+	if _, err := s.readConfig(); err == nil {
+		t.Error("Expected an error for an invalid timing action, got nil")
+	}
+}
+
+func TestPlanWriteTimings(t *testing.T) {
+	dir := t.TempDir()
+	q := mustParseQueryString(t, "src/sql/artist.sql", "SELECT :n;\n")
+	p := &Plan{
+		Query: q,
+		Path:  "regresql/plans/src/sql/artist.yaml",
+		Names: []string{"1", "2"},
+		ResultSets: []ResultSet{
+			{Duration: 1500 * time.Microsecond},
+			{Duration: 2 * time.Millisecond},
+		},
+	}
+	// no baseline is needed without a timing ratio
+	if err := p.WriteTimings(dir); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "artist.timings.yaml")); !os.IsNotExist(err) {
+		t.Fatalf("Expected no timings file without a timing ratio, got %v", err)
+	}
+
+	p.Options.Timing.Ratio = 3
+	if err := p.WriteTimings(dir); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	// only the second test case runs again, the first baseline is kept
+	p.Names = []string{"2"}
+	p.ResultSets = []ResultSet{{Duration: 3 * time.Millisecond}}
+	if err := p.WriteTimings(dir); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "artist.timings.yaml"))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	expected := "artist.1.out: 1.5\nartist.2.out: 3\n"
+	if string(data) != expected {
+		t.Errorf("Expected timings file:\n%s\ngot:\n%s", expected, data)
+	}
+
+	timings, err := readTimings(p, dir)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if timings["artist.1.out"] != 1500*time.Microsecond {
+		t.Errorf("Expected a 1.5ms baseline for artist.1.out, got %s", timings["artist.1.out"])
+	}
+}
+
+func TestCompareResultSetsTiming(t *testing.T) {
+	dir := t.TempDir()
+	q := mustParseQueryString(t, "src/sql/artist.sql", "SELECT 1;\n")
+	rs := ResultSet{
+		Cols:     []string{"?column?"},
+		Rows:     [][]interface{}{{int64(1)}},
+		Filename: filepath.Join(dir, "out", "artist.out"),
+		Duration: 10 * time.Millisecond,
+	}
+	p := &Plan{Query: q, Path: "regresql/plans/src/sql/artist.yaml",
+		ResultSets: []ResultSet{rs}}
+
+	os.MkdirAll(filepath.Join(dir, "out"), 0755)
+	if err := rs.Write(filepath.Join(dir, "artist.out"), true); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := rs.Write(rs.Filename, true); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "artist.timings.yaml"),
+		[]byte("artist.out: 2\n"), 0644); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	for _, action := range []string{"warn", "fail"} {
+		var b bytes.Buffer
+		r, _ := NewReporter("json", &b)
+		p.Options.Timing = Timing{Ratio: 3, Action: action}
+
+		failures, _ := p.CompareResultSets(dir, dir, r, 0)
+		if err := r.Finish(); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+
+		var report jsonReport
+		if err := json.Unmarshal(b.Bytes(), &report); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		result := report.Results[0]
+		if result.Baseline != 2 || !strings.Contains(result.Slow, "5.0x") {
+			t.Errorf("%s: expected a 5.0x regression on the 2ms baseline, got %+v", action, result)
+		}
+		if expected := map[string]int{"warn": 0, "fail": 1}[action]; failures != expected {
+			t.Errorf("%s: expected %d failure(s), got %d", action, expected, failures)
+		}
+	}
+}
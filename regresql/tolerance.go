@@ -0,0 +1,233 @@
+package regresql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+A Tolerance is a rule to compare numeric values, either for every column
+when Column is empty, or for a single column. Two numbers are considered
+equal when they are within Abs of each other, or within Rel times the
+largest of them, or when they are the same once rounded to Digits
+significant digits. Zero values disable a criterion.
+
+Tolerances are set in regress.yaml:
+
+	tolerance:
+	  - rel: 1e-9
+	  - column: avg_price
+	    digits: 6
+
+or with a query file header, or a plan file option:
+
+	-- regresql: tolerance column=avg_price digits=6
+*/
+type Tolerance struct {
+	Column string  `mapstructure:"column"`
+	Abs    float64 `mapstructure:"abs"`
+	Rel    float64 `mapstructure:"rel"`
+	Digits int     `mapstructure:"digits"`
+}
+
+// parseTolerance returns the Tolerance from directive arguments given as
+// key=value pairs.
+func parseTolerance(args []string) (Tolerance, error) {
+	var t Tolerance
+
+	for _, arg := range args {
+		kv, err := splitKeyValue("tolerance", arg)
+		if err != nil {
+			return t, err
+		}
+		switch kv[0] {
+		case "column":
+			t.Column = kv[1]
+		case "abs":
+			t.Abs, err = strconv.ParseFloat(kv[1], 64)
+		case "rel":
+			t.Rel, err = strconv.ParseFloat(kv[1], 64)
+		case "digits":
+			t.Digits, err = strconv.Atoi(kv[1])
+		default:
+			return t, fmt.Errorf("unknown tolerance setting %q", kv[0])
+		}
+		if err != nil {
+			return t, fmt.Errorf("invalid tolerance %s value %q", kv[0], kv[1])
+		}
+	}
+	if t.Abs == 0 && t.Rel == 0 && t.Digits == 0 {
+		return t, fmt.Errorf("tolerance expects one of abs, rel or digits, got %v", args)
+	}
+	return t, nil
+}
+
+// splitKeyValue splits a key=value directive argument.
+func splitKeyValue(directive string, arg string) ([]string, error) {
+	kv := strings.SplitN(arg, "=", 2)
+	if len(kv) != 2 {
+		return nil, fmt.Errorf("%s expects key=value arguments, got %q", directive, arg)
+	}
+	return kv, nil
+}
+
+// equal returns true when the numbers a and b are equal within t.
+func (t Tolerance) equal(a float64, b float64) bool {
+	d := math.Abs(a - b)
+
+	switch {
+	case d == 0:
+		return true
+	case t.Abs > 0 && d <= t.Abs:
+		return true
+	case t.Rel > 0 && d <= t.Rel*math.Max(math.Abs(a), math.Abs(b)):
+		return true
+	case t.Digits > 0:
+		return strconv.FormatFloat(a, 'g', t.Digits, 64) ==
+			strconv.FormatFloat(b, 'g', t.Digits, 64)
+	}
+	return false
+}
+
+// tolerance returns the Tolerance to use for column, the last rule set for
+// that column winning over the last rule set for all columns, or nil.
+func (o Options) tolerance(column string) *Tolerance {
+	var all *Tolerance
+	for i := len(o.Tolerance) - 1; i >= 0; i-- {
+		switch o.Tolerance[i].Column {
+		case column:
+			return &o.Tolerance[i]
+		case "":
+			if all == nil {
+				all = &o.Tolerance[i]
+			}
+		}
+	}
+	return all
+}
+
+// cellsEqual returns true when the expected and actual values of column are
+// the same, or are numbers equal within the column tolerance.
+func (o Options) cellsEqual(column string, expected string, actual string) bool {
+	if expected == actual {
+		return true
+	}
+	t := o.tolerance(column)
+	if t == nil {
+		return false
+	}
+	e, err := strconv.ParseFloat(expected, 64)
+	if err != nil {
+		return false
+	}
+	a, err := strconv.ParseFloat(actual, 64)
+	if err != nil {
+		return false
+	}
+	return t.equal(e, a)
+}
+
+// linesEqual returns true when the pretty printed result sets expected and
+// actual have the same columns and rows, comparing the cells with the
+// tolerance options, see parsePretty and compareTables.
+func (o Options) linesEqual(expected []string, actual []string) bool {
+	e, err := parsePretty(expected)
+	if err != nil {
+		return false
+	}
+	a, err := parsePretty(actual)
+	if err != nil {
+		return false
+	}
+	if o.Unordered {
+		e.Rows, a.Rows = o.matchRows(e.Columns, e.Rows, a.Rows)
+	}
+	return compareTables(e, a, o) == ""
+}
+
+/*
+matchRows pairs the expected and actual rows of unordered result sets that
+are equal within the tolerance options, as sorting them on their text
+doesn't keep such rows in the same position: 0.30000001 and 0.29999999 sort
+apart from each other. It returns the rows reordered so that the pairs come
+first, in the expected rows order, followed by the rows left unmatched, in
+their own order.
+
+Rows with the same text are paired first, then each expected row is paired
+with the first actual row left that it is equal to within tolerance.
+*/
+func (o Options) matchRows(columns []column, expected [][]*string, actual [][]*string) ([][]*string, [][]*string) {
+	pairs := make([]int, len(expected))
+	used := make([]bool, len(actual))
+	for i := range pairs {
+		pairs[i] = -1
+	}
+
+	for _, equal := range []func(e, a []*string) bool{
+		func(e, a []*string) bool { return rowString(e) == rowString(a) },
+		func(e, a []*string) bool { return o.rowsEqual(columns, e, a) },
+	} {
+		for i, e := range expected {
+			for j, a := range actual {
+				if pairs[i] < 0 && !used[j] && equal(e, a) {
+					pairs[i], used[j] = j, true
+				}
+			}
+		}
+	}
+
+	var e, a, eLeft, aLeft [][]*string
+	for i, j := range pairs {
+		if j < 0 {
+			eLeft = append(eLeft, expected[i])
+			continue
+		}
+		e = append(e, expected[i])
+		a = append(a, actual[j])
+	}
+	for j, row := range actual {
+		if !used[j] {
+			aLeft = append(aLeft, row)
+		}
+	}
+	return append(e, eLeft...), append(a, aLeft...)
+}
+
+// rowsEqual returns true when the expected and actual rows have the same
+// values, the numbers being compared with the tolerance options.
+func (o Options) rowsEqual(columns []column, expected []*string, actual []*string) bool {
+	if len(expected) != len(columns) || len(actual) != len(columns) {
+		return false
+	}
+	for i, col := range columns {
+		e, a := expected[i], actual[i]
+		if (e == nil) != (a == nil) || (e != nil && !o.cellsEqual(col.Name, *e, *a)) {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffFilesOptions is like DiffFiles, but compares the result sets in files
+// a and b within the numeric tolerances of the options, when set. The diff
+// is empty when the result sets are considered the same. The rows of
+// Unordered result sets are sorted when written, and compared in order.
+func DiffFilesOptions(a string, b string, c int, o Options) (string, error) {
+	var a_lines, b_lines []string
+	var err error
+
+	if a_lines, err = readLines(a); err != nil {
+		return "", err
+	}
+
+	if b_lines, err = readLines(b); err != nil {
+		return "", err
+	}
+
+	if len(o.Tolerance) > 0 && o.linesEqual(a_lines, b_lines) {
+		return "", nil
+	}
+	return DiffLines(a, b, a_lines, b_lines, c), nil
+}
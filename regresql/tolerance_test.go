@@ -0,0 +1,175 @@
+package regresql
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+func TestToleranceEqual(t *testing.T) {
+	tests := []struct {
+		tolerance Tolerance
+		a, b      float64
+		expected  bool
+	}{
+		{Tolerance{Abs: 0.01}, 1.005, 1.01, true},
+		{Tolerance{Abs: 0.01}, 1.0, 1.02, false},
+		{Tolerance{Rel: 1e-9}, 12345678.9, 12345678.900000001, true},
+		{Tolerance{Rel: 1e-9}, 1.0, 1.00001, false},
+		{Tolerance{Digits: 6}, 3.14159265, 3.14159201, true},
+		{Tolerance{Digits: 6}, 3.14159265, 3.14149265, false},
+	}
+	for _, test := range tests {
+		if got := test.tolerance.equal(test.a, test.b); got != test.expected {
+			t.Errorf("%+v: expected equal(%g, %g) == %v", test.tolerance, test.a, test.b, test.expected)
+		}
+	}
+}
+
+func TestToleranceOptions(t *testing.T) {
+	s := writeConfig(t, "pguri: postgres:///db\ntolerance:\n  - abs: 0.5\n")
+	config, err := s.readConfig()
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(config.Tolerance) != 1 || config.Tolerance[0].Abs != 0.5 {
+		t.Fatalf("Expected an abs tolerance of 0.5 from regress.yaml, got %+v", config.Tolerance)
+	}
+
+	q := mustParseQueryString(t, "src/sql/q.sql",
+		"-- regresql: tolerance column=avg digits=3\nSELECT 1;\n")
+	opts, err := q.resolveOptions(config.Options)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !opts.cellsEqual("avg", "3.14159", "3.14") || opts.cellsEqual("avg", "3.1", "3.5") {
+		t.Errorf("Expected the avg column to be compared with 3 digits, got %+v", opts.tolerance("avg"))
+	}
+	if !opts.cellsEqual("sum", "3.1", "3.5") || opts.cellsEqual("sum", "3.1", "4") {
+		t.Errorf("Expected the sum column to be compared within 0.5, got %+v", opts.tolerance("sum"))
+	}
+	if opts.cellsEqual("name", "abc", "abd") {
+		t.Error("Expected text values to be compared exactly")
+	}
+	if len(config.Tolerance) != 1 {
+		t.Errorf("Expected the defaults to be left unchanged, got %+v", config.Tolerance)
+	}
+
+	q = mustParseQueryString(t, "src/sql/q.sql", "-- regresql: tolerance abs\nSELECT 1;\n")
+	if _, err := q.resolveOptions(Options{}); err == nil {
+		t.Error("Expected an error for an invalid tolerance, got nil")
+	}
+}
+
+func TestDiffFilesOptionsTolerance(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, rows [][]interface{}) string {
+		rs := ResultSet{Cols: []string{"genre", "avg"}, Rows: rows}
+		rs.SortRows()
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(rs.PrettyPrint()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	expected := write("expected.out", [][]interface{}{{"Rock", 0.30000000000000004}, {"Jazz", 1.5}})
+	actual := write("actual.out", [][]interface{}{{"Jazz", 1.5}, {"Rock", 0.3}})
+
+	opts := Options{Unordered: true, Tolerance: []Tolerance{{Digits: 12}}}
+	if diff, err := DiffFilesOptions(expected, actual, 3, opts); err != nil || diff != "" {
+		t.Errorf("Expected no diff within tolerance, got %q (%v)", diff, err)
+	}
+
+	opts.Tolerance = nil
+	if diff, err := DiffFilesOptions(expected, actual, 3, opts); err != nil || diff == "" {
+		t.Errorf("Expected a diff without tolerance, got %q (%v)", diff, err)
+	}
+}
+
+func TestPrettyPrintLines(t *testing.T) {
+	rs := ResultSet{
+		Cols: []string{"id", "body", "note"},
+		Rows: [][]interface{}{
+			{"1", "one\ntwo", "a | b"},
+			{"2", "three", "x\ny\nz"},
+		},
+	}
+	expected := "id | body  | note \n" +
+		"---+-------+------\n" +
+		"1  | one  +| a | b\n" +
+		"   | two   | \n" +
+		"2  | three | x    +\n" +
+		"   |       | y    +\n" +
+		"   |       | z\n"
+	out := rs.PrettyPrint()
+	if out != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, out)
+	}
+
+	table, err := parsePretty(difflib.SplitLines(out))
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if len(table.Rows) != len(rs.Rows) {
+		t.Fatalf("Expected %d rows, got %d", len(rs.Rows), len(table.Rows))
+	}
+	for i, row := range rs.Rows {
+		for j, value := range row {
+			if *table.Rows[i][j] != value {
+				t.Errorf("row %d, column %d: expected %q, got %q", i+1, j+1, value, *table.Rows[i][j])
+			}
+		}
+	}
+}
+
+func TestLinesEqualText(t *testing.T) {
+	lines := func(rows ...[]interface{}) []string {
+		rs := ResultSet{Cols: []string{"name", "avg"}, Rows: rows}
+		return difflib.SplitLines(rs.PrettyPrint())
+	}
+	opts := Options{Tolerance: []Tolerance{{Column: "avg", Abs: 0.01}}}
+
+	expected := lines([]interface{}{"a | 1", 0.5}, []interface{}{"b\nc", 1.0})
+	if !opts.linesEqual(expected, lines([]interface{}{"a | 1", 0.501}, []interface{}{"b\nc", 1.001})) {
+		t.Error("Expected the result sets to be equal within tolerance")
+	}
+	if opts.linesEqual(expected, lines([]interface{}{"a", "1 | 0.5"}, []interface{}{"b\nc", 1.0})) {
+		t.Error("Expected a value moved across the column separator to differ")
+	}
+	if opts.linesEqual(expected, lines([]interface{}{"a | 1", 0.5}, []interface{}{"b", 1.0}, []interface{}{"c", 1.0})) {
+		t.Error("Expected a value split into two rows to differ")
+	}
+}
+
+func TestUnorderedTolerance(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Unordered: true, Tolerance: []Tolerance{{Column: "avg", Abs: 0.001}}}
+
+	// 10.00001 sorts before 5, and 9.99999 after it
+	for _, ext := range []string{".out", ".csv"} {
+		write := func(name string, values ...string) string {
+			rs := ResultSet{Cols: []string{"avg"}, Types: []string{"numeric"}}
+			for _, v := range values {
+				rs.Rows = append(rs.Rows, []interface{}{v})
+			}
+			rs.SortRows()
+			path := filepath.Join(dir, name+ext)
+			if err := rs.Write(path, true); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}
+		expected := write("expected", "5", "10.00001")
+		actual := write("actual", "9.99999", "5")
+		changed := write("changed", "9.9", "5")
+
+		if diff, err := compareFiles(expected, actual, opts); err != nil || diff != "" {
+			t.Errorf("%s: expected no diff within tolerance, got %q (%v)", ext, diff, err)
+		}
+		if diff, err := compareFiles(expected, changed, opts); err != nil || diff == "" {
+			t.Errorf("%s: expected a diff out of tolerance, got %q (%v)", ext, diff, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package regresql
+
+import (
+	"sort"
+)
+
+// SortRows sorts the rows of the result set by the text representation of
+// their values, column after column, so that result sets returned in a
+// different order print the same. The rows of unordered result sets are
+// sorted before they are written, see WriteResultSets, and their result
+// files are then compared as the other ones.
+func (r *ResultSet) SortRows() {
+	keys := make([][]string, len(r.Rows))
+	for i, row := range r.Rows {
+		keys[i] = make([]string, len(row))
+		for j, value := range row {
+			keys[i][j] = valueToString(value)
+		}
+	}
+	sort.Sort(rowsByKey{r.Rows, keys})
+}
+
+// rowsByKey sorts rows along with their text keys.
+type rowsByKey struct {
+	rows [][]interface{}
+	keys [][]string
+}
+
+func (r rowsByKey) Len() int { return len(r.rows) }
+
+func (r rowsByKey) Swap(i, j int) {
+	r.rows[i], r.rows[j] = r.rows[j], r.rows[i]
+	r.keys[i], r.keys[j] = r.keys[j], r.keys[i]
+}
+
+func (r rowsByKey) Less(i, j int) bool {
+	return lessCells(r.keys[i], r.keys[j])
+}
+
+// lessCells compares two rows given as lists of cells.
+func lessCells(a []string, b []string) bool {
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
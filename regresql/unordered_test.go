@@ -0,0 +1,57 @@
+package regresql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortRows(t *testing.T) {
+	rs := ResultSet{
+		Cols: []string{"id", "name"},
+		Rows: [][]interface{}{{"2", "b"}, {"1", "z"}, {"1", "a"}},
+	}
+	rs.SortRows()
+
+	expected := "id | name\n---+-----\n1  | a\n1  | z\n2  | b\n"
+	if out := rs.PrettyPrint(); out != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestWriteResultSetsUnordered(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, rows [][]interface{}) string {
+		q := mustParseQueryString(t, "src/sql/"+name+".sql", "-- regresql: unordered\nSELECT 1;\n")
+		opts, err := q.resolveOptions(Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := &Plan{Query: q, Path: "regresql/plans/src/sql/" + name + ".yaml", Options: opts, ResultSets: []ResultSet{
+			{Cols: []string{"id", "name"}, Rows: rows},
+		}}
+		if err := p.WriteResultSets(dir, 0); err != nil {
+			t.Fatal(err)
+		}
+		return p.ResultSets[0].Filename
+	}
+
+	expected := write("expected", [][]interface{}{{"1", "a | b"}, {"2", "b"}})
+	shuffled := write("shuffled", [][]interface{}{{"2", "b"}, {"1", "a | b"}})
+	changed := write("changed", [][]interface{}{{"2", "b"}, {"3", "c"}})
+
+	diff, err := DiffFiles(expected, shuffled, 3)
+	if err != nil || diff != "" {
+		t.Errorf("Expected no diff for shuffled rows, got %q (%v)", diff, err)
+	}
+
+	diff, err = DiffFiles(expected, changed, 3)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !strings.Contains(diff, "\n-1  | a | b\n") || !strings.Contains(diff, "\n+3  | c\n") {
+		t.Errorf("Expected the missing and added rows in the diff, got:\n%s", diff)
+	}
+	if strings.Contains(diff, "-2  | b") {
+		t.Errorf("Expected the common row not to be in the diff, got:\n%s", diff)
+	}
+}
@@ -0,0 +1,222 @@
+package regresql
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDelay is how long to wait for more changes before running the tests
+// again, as editors often write a file in several steps.
+const watchDelay = 200 * time.Millisecond
+
+/*
+watch runs the tests of the Suite once, and then again each time a query
+file, a plan file or a setup or teardown script changes, until interrupted.
+Only the query files affected by the changes are run again, the Suite being
+walked again from scanRoot so that new query files are found, and the
+filter still applies. Each run is reported with a compact summary.
+
+Watching stops when ctx is done, canceling the run in flight if any.
+*/
+func (s *Suite) watch(ctx context.Context, scanRoot string, filter Filter, config config, targets []target, opts RunOptions) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("Failed to watch files: %s\n", err)
+	}
+	defer watcher.Close()
+
+	// don't watch the expected and actual result files we write
+	if err := watchDirs(watcher, scanRoot, s.RegressDir); err != nil {
+		return err
+	}
+	if err := watchDirs(watcher, s.PlanDir, ""); err != nil {
+		return err
+	}
+
+	s.watchRun(ctx, config, targets, opts)
+
+	changed := make(map[string]bool)
+	var timer <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err := <-watcher.Errors:
+			fmt.Printf("Failed to watch files: %s\n", err)
+
+		case event := <-watcher.Events:
+			if event.Op&fsnotify.Create != 0 {
+				if stat, err := os.Stat(event.Name); err == nil && stat.IsDir() {
+					watchDirs(watcher, event.Name, s.RegressDir)
+				}
+			}
+			for _, relPath := range s.affected(event.Name) {
+				changed[relPath] = true
+			}
+			if len(changed) > 0 {
+				timer = time.After(watchDelay)
+			}
+
+		case <-timer:
+			timer = nil
+
+			sub := WalkFrom(s.Root, scanRoot, config.Exclude)
+			if err := sub.filter(config, filter); err != nil {
+				fmt.Printf(err.Error())
+				continue
+			}
+			sub.only(changed)
+			changed = make(map[string]bool)
+
+			if len(sub.Dirs) > 0 {
+				sub.watchRun(ctx, config, targets, opts)
+			}
+		}
+	}
+}
+
+// watchDirs adds dir and its subdirectories to the watcher, skipping the
+// skip directory and hidden directories.
+func watchDirs(watcher *fsnotify.Watcher, dir string, skip string) error {
+	return filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+		if err != nil || !f.IsDir() {
+			return nil
+		}
+		if path == skip || (path != dir && strings.HasPrefix(f.Name(), ".")) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("Failed to watch directory '%s': %s\n", path, err)
+		}
+		return nil
+	})
+}
+
+// affected returns the query files of the Suite, relative to its root,
+// that need to run again when filename changes: the query file itself, the
+// query file of a plan file, or every query file of the directory of a
+// setup or teardown script. The query file of a plan file is returned even
+// when it is not in the Suite, as it may have been added since.
+func (s *Suite) affected(filename string) []string {
+	var files []string
+
+	switch {
+	case isHookScript(filename):
+		dir, _ := filepath.Rel(s.Root, filepath.Dir(filename))
+		for _, folder := range s.Dirs {
+			if folder.Dir == dir {
+				for _, name := range folder.Files {
+					files = append(files, filepath.Join(dir, name))
+				}
+			}
+		}
+
+	case filepath.Ext(filename) == ".sql":
+		relPath, _ := filepath.Rel(s.Root, filename)
+		files = append(files, relPath)
+
+	case filepath.Ext(filename) == ".yaml":
+		// map the plan file back to its query file, which may have been
+		// added after s was walked, see getPlanPath
+		planPath, err := filepath.Rel(s.PlanDir, filename)
+		if err == nil && !strings.HasPrefix(planPath, "..") {
+			files = append(files, strings.TrimSuffix(planPath, ".yaml")+".sql")
+		}
+	}
+	return files
+}
+
+// only removes from the Suite the query files that are not in files.
+func (s *Suite) only(files map[string]bool) {
+	var dirs []Folder
+
+	for _, folder := range s.Dirs {
+		kept := Folder{Dir: folder.Dir}
+		for _, name := range folder.Files {
+			if files[filepath.Join(folder.Dir, name)] {
+				kept.Files = append(kept.Files, name)
+			}
+		}
+		if len(kept.Files) > 0 {
+			dirs = append(dirs, kept)
+		}
+	}
+	s.Dirs = dirs
+}
+
+// watchRun runs the Suite tests and outputs a summary of the results.
+func (s *Suite) watchRun(ctx context.Context, config config, targets []target, opts RunOptions) {
+	fmt.Printf("\n[%s] Running tests\n", time.Now().Format("15:04:05"))
+
+	// failures and bail outs are already in the summary
+	switch err := s.runTests(ctx, config, targets, opts, &summaryReporter{w: os.Stdout}); err.(type) {
+	case nil, *ErrTestsFailed, *ErrBailOut:
+	default:
+		fmt.Printf(err.Error())
+	}
+	fmt.Println("Watching for changes, press Ctrl-C to stop")
+}
+
+// summaryReporter outputs a line per failed or slow test, with its diff,
+// and a summary line, but nothing about the tests that passed.
+type summaryReporter struct {
+	w       io.Writer
+	tests   int
+	failed  int
+	errored int
+}
+
+func (r *summaryReporter) Header() {}
+
+func (r *summaryReporter) Report(result TestResult) {
+	r.tests++
+
+	if result.Slow != "" {
+		fmt.Fprintf(r.w, "SLOW %s: %s\n", result.Name, result.Slow)
+	}
+	if result.Passed {
+		return
+	}
+	switch {
+	case result.Errored:
+		r.errored++
+		fmt.Fprintf(r.w, "ERROR %s\n", result.Name)
+	case result.TimedOut:
+		r.failed++
+		fmt.Fprintf(r.w, "TIMEOUT %s\n", result.Name)
+	default:
+		r.failed++
+		fmt.Fprintf(r.w, "FAIL %s\n", result.Name)
+	}
+	if result.Error != "" {
+		fmt.Fprintf(r.w, "%s\n", strings.TrimRight(result.Error, "\n"))
+	}
+	if result.Diff != "" {
+		fmt.Fprint(r.w, result.Diff)
+	}
+}
+
+func (r *summaryReporter) BailOut(reason string) {
+	fmt.Fprintf(r.w, "Bail out! %s\n", reason)
+}
+
+func (r *summaryReporter) Finish() error {
+	switch {
+	case r.errored > 0:
+		fmt.Fprintf(r.w, "FAIL: %d of %d tests failed, %d errored\n", r.failed, r.tests, r.errored)
+	case r.failed > 0:
+		fmt.Fprintf(r.w, "FAIL: %d of %d tests failed\n", r.failed, r.tests)
+	default:
+		fmt.Fprintf(r.w, "ok: %d tests passed\n", r.tests)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package regresql
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSuiteAffected(t *testing.T) {
+	s := newSuite("/project")
+	s.Dirs = []Folder{
+		{"src/sql", []string{"artist.sql", "genre.sql"}},
+		{"src/reports", []string{"sales.sql"}},
+	}
+
+	tests := []struct {
+		filename string
+		expected []string
+	}{
+		{"/project/src/sql/artist.sql", []string{"src/sql/artist.sql"}},
+		{"/project/src/sql/new.sql", []string{"src/sql/new.sql"}},
+		{"/project/regresql/plans/src/sql/genre.yaml", []string{"src/sql/genre.sql"}},
+		{"/project/regresql/plans/src/sql/new.yaml", []string{"src/sql/new.sql"}},
+		{"/project/regresql/regress.yaml", nil},
+		{"/project/src/sql/setup.sql", []string{"src/sql/artist.sql", "src/sql/genre.sql"}},
+		{"/project/src/sql/README.md", nil},
+	}
+	for _, test := range tests {
+		if got := s.affected(test.filename); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Expected %s to affect %v, got %v", test.filename, test.expected, got)
+		}
+	}
+}
+
+func TestSuiteOnly(t *testing.T) {
+	s := newSuite("/project")
+	s.Dirs = []Folder{
+		{"src/sql", []string{"artist.sql", "genre.sql"}},
+		{"src/reports", []string{"sales.sql"}},
+	}
+	s.only(map[string]bool{
+		filepath.Join("src/sql", "genre.sql"): true,
+		filepath.Join("src/sql", "gone.sql"):  true,
+	})
+
+	expected := []Folder{{"src/sql", []string{"genre.sql"}}}
+	if !reflect.DeepEqual(s.Dirs, expected) {
+		t.Errorf("Expected %v, got %v", expected, s.Dirs)
+	}
+}
+
+func TestSummaryReporter(t *testing.T) {
+	var b bytes.Buffer
+	r := &summaryReporter{w: &b}
+
+	r.Header()
+	r.Report(TestResult{Name: "src/sql/artist.1.out", Passed: true})
+	r.Report(TestResult{Name: "src/sql/genre.1.out", Passed: false, Diff: "-Rock\n+Jazz\n"})
+	if err := r.Finish(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	out := b.String()
+	if strings.Contains(out, "artist") {
+		t.Errorf("Expected passing tests to be left out of the summary, got %q", out)
+	}
+	if !strings.Contains(out, "FAIL src/sql/genre.1.out\n-Rock\n+Jazz\n") ||
+		!strings.HasSuffix(out, "FAIL: 1 of 2 tests failed\n") {
+		t.Errorf("Expected the failed test and a summary line, got %q", out)
+	}
+}